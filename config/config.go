@@ -0,0 +1,85 @@
+package config
+
+import (
+	"sync"
+)
+
+// Store ... envs shared by the handlers, safe to be changed while serving.
+// Overlay envs, like the ones of a scenario phase, hide the base ones until cleared.
+type Store struct {
+	mu      sync.RWMutex
+	envs    map[string]string
+	overlay map[string]string
+}
+
+// NewStore ... create a store filled with a copy of envs
+func NewStore(envs map[string]string) *Store {
+	s := &Store{
+		envs: make(map[string]string, len(envs)),
+	}
+	for key, value := range envs {
+		s.envs[key] = value
+	}
+
+	return s
+}
+
+// Get ... value of a single env
+func (s *Store) Get(key string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if value, ok := s.overlay[key]; ok {
+		return value
+	}
+
+	return s.envs[key]
+}
+
+// Set ... change the base value of an env and return the previous one
+func (s *Store) Set(key string, value string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := s.envs[key]
+	s.envs[key] = value
+
+	return old
+}
+
+// Overlaid ... true if an overlay env hides the base value of key
+func (s *Store) Overlaid(key string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.overlay[key]
+
+	return ok
+}
+
+// SetOverlay ... replace the overlay envs, nil clears them
+func (s *Store) SetOverlay(envs map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.overlay = make(map[string]string, len(envs))
+	for key, value := range envs {
+		s.overlay[key] = value
+	}
+}
+
+// Snapshot ... copy of all the envs, consistent at the time of the call
+func (s *Store) Snapshot() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	envs := make(map[string]string, len(s.envs)+len(s.overlay))
+	for key, value := range s.envs {
+		envs[key] = value
+	}
+	for key, value := range s.overlay {
+		envs[key] = value
+	}
+
+	return envs
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/efbar/minimal-service/config"
+	"github.com/efbar/minimal-service/helpers"
+	"github.com/efbar/minimal-service/logging"
+	"golang.org/x/net/http/httpguts"
+)
+
+// staticEnvs ... envs used only at start, they can't be changed at runtime
+var staticEnvs = []string{
+	"HTTPS",
+	"SERVICE_PORT",
+	"CONNECT",
+	"CONNECT_TLS",
+	"JAEGER_URL",
+	"CONSUL_AGENT",
+	"CONSUL_HTTP_TOKEN",
+	"CONSUL_CACERT",
+	"HOST_IP",
+	"POD_IP",
+	"POD_NAME",
+	"POD_NAMESPACE",
+	"SCENARIO_FILE",
+	"STARTUP_DELAY",
+	"ADMIN_TOKEN",
+	"ADMIN_CLIENT_CERTS",
+	"ADMIN_CIDRS",
+}
+
+// envValidators ... checks on the values of the envs changed at runtime
+var envValidators = map[string]func(string) error{
+	"HEALTH_FAIL":               validInt,
+	"HEALTH_FAIL_AFTER":         validInt,
+	"HEALTH_FLAP_INTERVAL":      validInt,
+	"HEALTH_FAIL_PERCENT":       validInt,
+	"HEALTH_DELAY_MS":           validInt,
+	"LIVE_FAIL":                 validInt,
+	"READY_FAIL":                validInt,
+	"READY_DEPENDENCIES":        validDependencies,
+	"CERT_WARN_DAYS":            validInt,
+	"BOUNCE_MAX_BODY":           validInt,
+	"BOUNCE_TIMEOUT_MS":         validInt,
+	"BOUNCE_OVERALL_TIMEOUT_MS": validInt,
+	"BOUNCE_RETRIES":            validInt,
+	"BOUNCE_BACKOFF_MS":         validInt,
+	"BOUNCE_BACKOFF_MAX_MS":     validInt,
+	"BOUNCE_RETRY_ON":           validStatusList,
+	"TRACE_PROPAGATORS":         validPropagators,
+	"BREAKER_FAILURES":          validInt,
+	"BREAKER_OPEN_SECONDS":      validInt,
+	"DRAIN_PERIOD":              validInt,
+	"SHUTDOWN_TIMEOUT":          validInt,
+	"STRESS_MAX_CORES":          validInt,
+	"STRESS_MAX_MEMORY_MIB":     validInt,
+	"STRESS_MAX_GOROUTINES":     validInt,
+	"STRESS_MAX_DISK_MIB":       validInt,
+	"STRESS_MAX_DURATION":       validInt,
+	"DISCARD_QUOTA":             validInt,
+	"REJECT":                    validInt,
+	"REJECT_STATUS":             validStatusWeights,
+	"REJECT_RETRY_AFTER":        validRetryAfter,
+	"DISCARD_MODE":              validDiscardMode,
+	"DRIP_RATE":                 validInt,
+	"HANG_MAX":                  validInt,
+	"FAULT_SEQUENCE":            validSequence,
+	"FAULT_SEQUENCE_KEY":        validSequenceKey,
+	"FAULT_SEQUENCE_LOOP":       validFlag,
+	"FAULT_HEADERS":             validFlag,
+	"FAULT_HASH_HEADER":         validHeaderName,
+	"DELAY_DISTRIBUTION":        validDistribution,
+	"DELAY_MAX":                 validInt,
+	"DELAY_PERCENT":             validInt,
+	"DELAY_MS":                  validFloat,
+	"DELAY_MIN_MS":              validFloat,
+	"DELAY_MAX_MS":              validFloat,
+	"DELAY_MEAN_MS":             validFloat,
+	"DELAY_STDDEV_MS":           validFloat,
+	"DELAY_P50_MS":              validFloat,
+	"DELAY_P90_MS":              validFloat,
+	"DELAY_P99_MS":              validFloat,
+}
+
+// Admin ...
+type Admin struct {
+	log  logging.Logger
+	envs *config.Store
+}
+
+// HandlerAdmin ...
+func HandlerAdmin(l logging.Logger, envs *config.Store) *Admin {
+	return &Admin{
+		log:  l,
+		envs: envs,
+	}
+}
+
+// ServeHTTP ... GET lists the envs that can be changed at runtime, PUT changes some of them
+func (h *Admin) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	h.log.Debug(h.envs.Get("DEBUG"), r.Method, "on", r.URL.String(), "from", r.RemoteAddr)
+
+	switch r.Method {
+	case http.MethodGet:
+		h.encodeEnvs(rw)
+	case http.MethodPut:
+		body, _ := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+
+		changes := map[string]string{}
+		if err := json.Unmarshal(body, &changes); err != nil {
+			ErrorJSON(rw, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		if err := ValidateEnvs(changes); err != nil {
+			ErrorJSON(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// a change hidden by the scenario phase would be reported but not applied
+		for key := range changes {
+			if h.envs.Overlaid(key) {
+				ErrorJSON(rw, fmt.Sprintf("env %s is set by the running scenario phase", key), http.StatusConflict)
+				return
+			}
+		}
+
+		keys := make([]string, 0, len(changes))
+		for key := range changes {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			old := h.envs.Set(key, changes[key])
+			h.log.Info("Admin:", key, "changed from", strconv.Quote(old), "to", strconv.Quote(changes[key]), "by", r.RemoteAddr)
+		}
+
+		h.encodeEnvs(rw)
+	default:
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// encodeEnvs ... send the envs that can be changed at runtime
+func (h *Admin) encodeEnvs(rw http.ResponseWriter) {
+	envs := h.envs.Snapshot()
+	for _, key := range staticEnvs {
+		delete(envs, key)
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(envs); err != nil {
+		h.log.Error("error encoding json", err.Error())
+	}
+}
+
+// ValidateEnvs ... check that every env can be changed at runtime and has a valid value
+func ValidateEnvs(changes map[string]string) error {
+	for key, value := range changes {
+		if !helpers.KnownEnv(key) {
+			return fmt.Errorf("unknown env %s", key)
+		}
+		if contains(staticEnvs, key) {
+			return fmt.Errorf("env %s can't be changed at runtime", key)
+		}
+		if valid, ok := envValidators[key]; ok && len(value) != 0 {
+			if err := valid(value); err != nil {
+				return fmt.Errorf("env %s: %s", key, err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
+func validFloat(value string) error {
+	_, err := strconv.ParseFloat(value, 64)
+	return err
+}
+
+func validFlag(value string) error {
+	if value != "0" && value != "1" {
+		return fmt.Errorf("must be 0 or 1")
+	}
+	return nil
+}
+
+func validHeaderName(value string) error {
+	if !httpguts.ValidHeaderFieldName(value) {
+		return fmt.Errorf("not valid header name %s", value)
+	}
+	return nil
+}
+
+func contains(listS []string, s string) bool {
+	for _, value := range listS {
+		if value == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/efbar/minimal-service/config"
+	"github.com/efbar/minimal-service/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupAdminTest(t *testing.T, envs *config.Store) *Admin {
+	l := log.New(os.Stdout,
+		"Test Logger: ",
+		log.Ldate|log.Ltime)
+	logger := &logging.Logger{
+		Logger: l,
+	}
+
+	return HandlerAdmin(*logger, envs)
+}
+
+func TestAdminResp(t *testing.T) {
+	tt := []struct {
+		name   string
+		method string
+		body   string
+		status int
+		envs   map[string]string
+	}{
+		{
+			name:   "GET request",
+			method: "GET",
+			status: http.StatusOK,
+			envs:   map[string]string{"DISCARD_QUOTA": "0", "REJECT": "0"},
+		},
+		{
+			name:   "PUT request",
+			method: "PUT",
+			body:   `{"DISCARD_QUOTA":"30","REJECT_STATUS":"503:60,429:40"}`,
+			status: http.StatusOK,
+			envs:   map[string]string{"DISCARD_QUOTA": "30", "REJECT": "0", "REJECT_STATUS": "503:60,429:40"},
+		},
+		{
+			name:   "PUT request, per code body",
+			method: "PUT",
+			body:   `{"REJECT_BODY_503":"later"}`,
+			status: http.StatusOK,
+			envs:   map[string]string{"REJECT_BODY_503": "later"},
+		},
+		{
+			name:   "PUT request, unknown env",
+			method: "PUT",
+			body:   `{"FOO":"1"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "PUT request, static env",
+			method: "PUT",
+			body:   `{"SERVICE_PORT":"8080"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "PUT request, Jaeger collector",
+			method: "PUT",
+			body:   `{"JAEGER_URL":"http://jaeger:14268/api/traces"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "PUT request, not valid value",
+			method: "PUT",
+			body:   `{"DISCARD_QUOTA":"many"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "PUT request, unknown delay distribution",
+			method: "PUT",
+			body:   `{"DELAY_DISTRIBUTION":"pareto"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "PUT request, delay distribution",
+			method: "PUT",
+			body:   `{"DELAY_DISTRIBUTION":"lognormal","DELAY_MEAN_MS":"100"}`,
+			status: http.StatusOK,
+			envs:   map[string]string{"DELAY_DISTRIBUTION": "lognormal", "DELAY_MEAN_MS": "100"},
+		},
+		{
+			name:   "PUT request, not valid flag",
+			method: "PUT",
+			body:   `{"FAULT_HEADERS":"off"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "PUT request, not valid header name",
+			method: "PUT",
+			body:   `{"FAULT_HASH_HEADER":"X Request ID"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "PUT request, not valid json",
+			method: "PUT",
+			body:   `DISCARD_QUOTA=30`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "POST request",
+			method: "POST",
+			status: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tr := range tt {
+		t.Run(tr.name, func(t *testing.T) {
+			store := config.NewStore(map[string]string{
+				"SERVICE_PORT":  "9090",
+				"DISCARD_QUOTA": "0",
+				"REJECT":        "0",
+			})
+			handler := setupAdminTest(t, store)
+
+			req := httptest.NewRequest(tr.method, "/admin/faults", strings.NewReader(tr.body))
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tr.status, rr.Code)
+			if tr.status == http.StatusOK {
+				envs := map[string]string{}
+				assert.NoError(t, json.NewDecoder(rr.Body).Decode(&envs))
+				assert.NotContains(t, envs, "SERVICE_PORT")
+				for key, value := range tr.envs {
+					assert.Equal(t, value, envs[key])
+					assert.Equal(t, value, store.Get(key))
+				}
+			}
+		})
+	}
+}
+
+func TestAdminChangesServing(t *testing.T) {
+	store := config.NewStore(map[string]string{
+		"DELAY_MAX": "0",
+		"TRACING":   "0",
+	})
+	admin := setupAdminTest(t, store)
+	handler := setupReqHTTPTest(t)
+	handler.envs = store
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = httptest.NewRecorder()
+	admin.ServeHTTP(rr, httptest.NewRequest("PUT", "/admin/faults",
+		strings.NewReader(`{"DISCARD_QUOTA":"100","REJECT":"1","REJECT_STATUS":"503"}`)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+
+	rr = httptest.NewRecorder()
+	admin.ServeHTTP(rr, httptest.NewRequest("PUT", "/admin/faults",
+		strings.NewReader(`{"DISCARD_QUOTA":"0"}`)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestAdminScenarioOverlay(t *testing.T) {
+	store := config.NewStore(map[string]string{
+		"DISCARD_QUOTA": "0",
+		"REJECT":        "0",
+	})
+	store.SetOverlay(map[string]string{"REJECT": "1"})
+	handler := setupAdminTest(t, store)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("PUT", "/admin/faults", strings.NewReader(`{"DISCARD_QUOTA":"30","REJECT":"0"}`)))
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Equal(t, "0", store.Get("DISCARD_QUOTA"))
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("PUT", "/admin/faults", strings.NewReader(`{"DISCARD_QUOTA":"30"}`)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "30", store.Get("DISCARD_QUOTA"))
+
+	store.SetOverlay(nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("PUT", "/admin/faults", strings.NewReader(`{"REJECT":"1"}`)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
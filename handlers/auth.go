@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/efbar/minimal-service/config"
+	"github.com/efbar/minimal-service/logging"
+)
+
+// Auth ... access control in front of the destructive and admin endpoints,
+// every check configured has to pass
+type Auth struct {
+	log  logging.Logger
+	envs *config.Store
+	next http.Handler
+}
+
+// HandlerAuth ... serve next only to authorized clients
+func HandlerAuth(l logging.Logger, envs *config.Store, next http.Handler) *Auth {
+	return &Auth{
+		log:  l,
+		envs: envs,
+		next: next,
+	}
+}
+
+// ServeHTTP ...
+func (h *Auth) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	code, reason := h.authorize(r)
+	if code == http.StatusOK {
+		h.next.ServeHTTP(rw, r)
+		return
+	}
+
+	r, span := startServerSpan(r, h.log, h.envs)
+	defer span.End()
+
+	h.log.Error("Unauthorized", r.Method, "on", r.URL.Path, "from", r.RemoteAddr+",", reason)
+	if code == http.StatusUnauthorized {
+		rw.Header().Set("WWW-Authenticate", `Bearer realm="minimal-service"`)
+	}
+	ErrorJSON(rw, http.StatusText(code), code)
+
+	execTracing(r.Context(), h.envs, code, reason, map[string]string{
+		"Method":     r.Method,
+		"Path":       r.URL.Path,
+		"RemoteAddr": r.RemoteAddr,
+		"StatusCode": strconv.Itoa(code),
+	})
+}
+
+// authorize ... 200 if r can go on, or the status code and why not
+func (h *Auth) authorize(r *http.Request) (int, string) {
+	if token := h.envs.Get("ADMIN_TOKEN"); len(token) != 0 {
+		given, ok := bearerToken(r.Header.Get("Authorization"))
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			return http.StatusUnauthorized, "missing or wrong bearer token"
+		}
+	}
+
+	if cidrs := h.envs.Get("ADMIN_CIDRS"); len(cidrs) != 0 && !h.allowedAddr(r.RemoteAddr, cidrs) {
+		return http.StatusForbidden, "source address not allowed"
+	}
+
+	if names := h.envs.Get("ADMIN_CLIENT_CERTS"); len(names) != 0 && !allowedCert(r, names) {
+		return http.StatusForbidden, "client certificate not allowed"
+	}
+
+	return http.StatusOK, ""
+}
+
+// bearerToken ... the token of an Authorization header with the Bearer scheme, in any case
+func bearerToken(header string) (string, bool) {
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+
+	return strings.TrimSpace(token), true
+}
+
+// allowedAddr ... true if the ip of addr is in one of the comma separated cidrs
+func (h *Auth) allowedAddr(addr string, cidrs string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, cidr := range strings.Split(cidrs, ",") {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			h.log.Error("ADMIN_CIDRS,", err.Error())
+			continue
+		}
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowedCert ... true if the verified client certificate has a common name,
+// DNS or URI SAN in the comma separated names
+func allowedCert(r *http.Request, names string) bool {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return false
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+
+	identities := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
+	}
+
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); len(name) != 0 && contains(identities, name) {
+			return true
+		}
+	}
+
+	return false
+}
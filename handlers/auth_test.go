@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/efbar/minimal-service/config"
+	"github.com/efbar/minimal-service/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuth(t *testing.T) {
+	l := log.New(os.Stdout,
+		"Test Logger: ",
+		log.Ldate|log.Ltime)
+	logger := &logging.Logger{
+		Logger: l,
+	}
+
+	spiffe, _ := url.Parse("spiffe://dc1/ns/default/svc/operator")
+	clientCert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "admin"},
+		DNSNames: []string{"ops.example.com"},
+		URIs:     []*url.URL{spiffe},
+	}
+
+	tt := []struct {
+		name       string
+		envs       map[string]string
+		token      string
+		remoteAddr string
+		cert       *x509.Certificate
+		status     int
+	}{
+		{
+			name:   "no access control",
+			status: http.StatusOK,
+		},
+		{
+			name:   "right token",
+			envs:   map[string]string{"ADMIN_TOKEN": "s3cr3t"},
+			token:  "Bearer s3cr3t",
+			status: http.StatusOK,
+		},
+		{
+			name:   "wrong token",
+			envs:   map[string]string{"ADMIN_TOKEN": "s3cr3t"},
+			token:  "Bearer guess",
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "bearer scheme in any case",
+			envs:   map[string]string{"ADMIN_TOKEN": "s3cr3t"},
+			token:  "bearer s3cr3t",
+			status: http.StatusOK,
+		},
+		{
+			name:   "token without scheme",
+			envs:   map[string]string{"ADMIN_TOKEN": "s3cr3t"},
+			token:  "s3cr3t",
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "token with another scheme",
+			envs:   map[string]string{"ADMIN_TOKEN": "s3cr3t"},
+			token:  "Basic s3cr3t",
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "missing token",
+			envs:   map[string]string{"ADMIN_TOKEN": "s3cr3t"},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:       "allowed source address",
+			envs:       map[string]string{"ADMIN_CIDRS": "10.0.0.0/8, 192.168.1.0/24"},
+			remoteAddr: "192.168.1.7:53211",
+			status:     http.StatusOK,
+		},
+		{
+			name:       "not allowed source address",
+			envs:       map[string]string{"ADMIN_CIDRS": "10.0.0.0/8"},
+			remoteAddr: "192.168.1.7:53211",
+			status:     http.StatusForbidden,
+		},
+		{
+			name:   "allowed client certificate by common name",
+			envs:   map[string]string{"ADMIN_CLIENT_CERTS": "admin"},
+			cert:   clientCert,
+			status: http.StatusOK,
+		},
+		{
+			name:   "allowed client certificate by URI SAN",
+			envs:   map[string]string{"ADMIN_CLIENT_CERTS": "someone,spiffe://dc1/ns/default/svc/operator"},
+			cert:   clientCert,
+			status: http.StatusOK,
+		},
+		{
+			name:   "not allowed client certificate",
+			envs:   map[string]string{"ADMIN_CLIENT_CERTS": "someone"},
+			cert:   clientCert,
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "missing client certificate",
+			envs:   map[string]string{"ADMIN_CLIENT_CERTS": "admin"},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "every check has to pass",
+			envs: map[string]string{
+				"ADMIN_TOKEN": "s3cr3t",
+				"ADMIN_CIDRS": "10.0.0.0/8",
+			},
+			token:      "Bearer s3cr3t",
+			remoteAddr: "192.168.1.7:53211",
+			status:     http.StatusForbidden,
+		},
+	}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	for _, tr := range tt {
+		handler := HandlerAuth(*logger, config.NewStore(tr.envs), next)
+
+		t.Run(tr.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/crash", nil)
+			if tr.token != "" {
+				req.Header.Set("Authorization", tr.token)
+			}
+			if tr.remoteAddr != "" {
+				req.RemoteAddr = tr.remoteAddr
+			}
+			if tr.cert != nil {
+				req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{tr.cert}}}
+			}
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tr.status, rr.Code)
+			if tr.status == http.StatusUnauthorized {
+				assert.Contains(t, rr.Header().Get("WWW-Authenticate"), "Bearer")
+			}
+		})
+	}
+}
+
+func TestAuthTracing(t *testing.T) {
+	sr := recordSpans(t)
+	l := log.New(os.Stdout,
+		"Test Logger: ",
+		log.Ldate|log.Ltime)
+	logger := &logging.Logger{
+		Logger: l,
+	}
+	store := config.NewStore(map[string]string{"TRACING": "1", "ADMIN_TOKEN": "s3cr3t"})
+	handler := HandlerAuth(*logger, store, http.NotFoundHandler())
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/crash", nil))
+
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	spans := sr.Completed()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, "401", spans[0].Attributes()["StatusCode"].AsString())
+	}
+}
@@ -0,0 +1,378 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UpstreamResult ... outcome of a request bounced to an upstream
+type UpstreamResult struct {
+	Endpoint   string  `json:"endpoint"`
+	ServedBy   string  `json:"servedBy,omitempty"`
+	StatusCode int     `json:"statuscode,omitempty"`
+	Duration   float64 `json:"duration"`
+	Attempts   int     `json:"attempts,omitempty"`
+	Breaker    string  `json:"breaker,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// responseModes ... how the response of a bounced request is sent back
+var responseModes = []string{
+	"legacy",      // this instance response, with the upstream status as body
+	"nested",      // this instance response, with the upstream status, headers and body nested
+	"transparent", // the upstream response as it is
+}
+
+// hopByHopHeaders ... headers of a single connection, not proxied
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// UpstreamResponse ... the response of the upstream, with the body truncated to BOUNCE_MAX_BODY bytes
+type UpstreamResponse struct {
+	StatusCode int               `json:"statuscode"`
+	Headers    map[string]string `json:"headers"`
+	Body       string            `json:"body"`
+	Truncated  bool              `json:"truncated,omitempty"`
+	Attempts   int               `json:"attempts"`
+	Breaker    string            `json:"breaker"`
+}
+
+// succeeded ... the upstream answered with a 2xx status
+func (u UpstreamResult) succeeded() bool {
+	return len(u.Error) == 0 && u.StatusCode >= 200 && u.StatusCode < 300
+}
+
+// hopServe ... forward the request to the first of hops with the remainder,
+// then answer with the results of every hop down the chain
+func (h *Data) hopServe(rw http.ResponseWriter, r *http.Request, st *time.Time, body []byte, jp *JSONPost) error {
+	policy, err := h.retryPolicy(jp)
+	if err != nil {
+		ErrorJSON(rw, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	code := http.StatusOK
+	var results []UpstreamResult
+	if len(jp.Hops) != 0 {
+		results = h.forwardHop(r, jp, policy)
+		for _, result := range results {
+			if result.StatusCode != http.StatusOK {
+				code = http.StatusBadGateway
+			}
+		}
+	}
+
+	return h.answerBounce(rw, r, st, body, code, func(js *JSONResponse) {
+		js.Hops = results
+	})
+}
+
+// fanoutServe ... call every endpoint at once and answer as the mode asks
+func (h *Data) fanoutServe(rw http.ResponseWriter, r *http.Request, st *time.Time, body []byte, jp *JSONPost) error {
+	needed, err := fanoutNeeded(jp)
+	if err != nil {
+		ErrorJSON(rw, err.Error(), http.StatusBadRequest)
+		return err
+	}
+	policy, err := h.retryPolicy(jp)
+	if err != nil {
+		ErrorJSON(rw, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	results, code := h.fanout(r, jp, needed, policy)
+
+	return h.answerBounce(rw, r, st, body, code, func(js *JSONResponse) {
+		js.Targets = results
+	})
+}
+
+// answerBounce ... answer with code and the response of this instance, completed by fill
+func (h *Data) answerBounce(rw http.ResponseWriter, r *http.Request, st *time.Time, body []byte, code int, fill func(js *JSONResponse)) error {
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	js, err := h.shapingJSON(r, st)
+	if err != nil {
+		h.l.Error("error shaping json", err.Error())
+		http.Error(rw, "Internal Server Error", http.StatusInternalServerError)
+		return err
+	}
+	js.StatusCode = code
+	fill(js)
+
+	rw.WriteHeader(code)
+	if err = js.EncodeJSON(rw); err != nil {
+		return err
+	}
+
+	h.execTracing(r.Context(), code, http.StatusText(code), js.Headers)
+
+	return nil
+}
+
+// fanoutNeeded ... how many endpoints have to succeed for the mode of the payload
+func fanoutNeeded(jp *JSONPost) (int, error) {
+	switch jp.Mode {
+	case "", "all":
+		return len(jp.Endpoints), nil
+	case "first":
+		return 1, nil
+	case "quorum":
+		if jp.Quorum == 0 {
+			return len(jp.Endpoints)/2 + 1, nil
+		}
+		if jp.Quorum < 0 || jp.Quorum > len(jp.Endpoints) {
+			return 0, fmt.Errorf("quorum must be between 1 and %d", len(jp.Endpoints))
+		}
+		return jp.Quorum, nil
+	}
+
+	return 0, fmt.Errorf("unknown mode %s", jp.Mode)
+}
+
+// fanout ... call the endpoints concurrently until needed of them succeeded, or it can't happen anymore,
+// or the deadline is over. The calls still running then are cancelled
+func (h *Data) fanout(r *http.Request, jp *JSONPost, needed int, policy retryPolicy) ([]UpstreamResult, int) {
+	deadlineCtx := r.Context()
+	if jp.DeadlineMs > 0 {
+		var cancel context.CancelFunc
+		deadlineCtx, cancel = context.WithTimeout(deadlineCtx, time.Duration(jp.DeadlineMs)*time.Millisecond)
+		defer cancel()
+	}
+	ctx, cancel := context.WithCancel(deadlineCtx)
+	defer cancel()
+
+	results := make([]UpstreamResult, len(jp.Endpoints))
+	done := make(chan int, len(jp.Endpoints))
+	for i, endpoint := range jp.Endpoints {
+		go func(i int, endpoint string) {
+			results[i] = h.callTarget(ctx, r, jp, endpoint, policy)
+			done <- i
+		}(i, endpoint)
+	}
+
+	succeeded := 0
+	for finished := 1; finished <= len(jp.Endpoints); finished++ {
+		if results[<-done].succeeded() {
+			succeeded++
+		}
+		if succeeded >= needed || succeeded+len(jp.Endpoints)-finished < needed {
+			cancel()
+		}
+	}
+
+	switch {
+	case succeeded >= needed:
+		return results, http.StatusOK
+	case errors.Is(deadlineCtx.Err(), context.DeadlineExceeded):
+		return results, http.StatusGatewayTimeout
+	}
+
+	return results, http.StatusBadGateway
+}
+
+// callTarget ... bounce the request of the payload to endpoint
+func (h *Data) callTarget(ctx context.Context, r *http.Request, jp *JSONPost, endpoint string, policy retryPolicy) UpstreamResult {
+	result := UpstreamResult{Endpoint: endpoint}
+
+	target := *jp
+	target.Endpoint = endpoint
+	newRequest := func() (*http.Request, error) {
+		return newUpstreamRequest(r, &target)
+	}
+
+	st := time.Now()
+	call := h.callUpstream(ctx, endpoint, policy, newRequest)
+	result.Duration = float64(time.Since(st)) / float64(time.Millisecond)
+	result.Attempts = call.attempts
+	result.Breaker = call.breaker
+	if call.err != nil {
+		result.Error = call.err.Error()
+		return result
+	}
+	resp := call.resp
+	defer resp.Body.Close()
+	h.l.Info(resp.Status, endpoint)
+
+	result.StatusCode = resp.StatusCode
+	js := &JSONResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(js); err == nil {
+		result.ServedBy = js.ServedBy
+	}
+
+	return result
+}
+
+// forwardHop ... bounce to the next hop, the results are the one of the next hop
+// followed by the ones it collected down the chain
+func (h *Data) forwardHop(r *http.Request, jp *JSONPost, policy retryPolicy) []UpstreamResult {
+	next := jp.Hops[0]
+	result := UpstreamResult{Endpoint: next}
+
+	payload, _ := json.Marshal(&JSONPost{
+		Rebound: "true",
+		Hops:    jp.Hops[1:],
+		Forward: jp.Forward,
+	})
+	newRequest := func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, next, bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		forwardHeaders(req, r, jp.Forward)
+		return req, nil
+	}
+
+	st := time.Now()
+	call := h.callUpstream(r.Context(), next, policy, newRequest)
+	result.Duration = float64(time.Since(st)) / float64(time.Millisecond)
+	result.Attempts = call.attempts
+	result.Breaker = call.breaker
+	if call.err != nil {
+		h.l.Error("Hop", next+",", call.err.Error())
+		result.Error = call.err.Error()
+		return []UpstreamResult{result}
+	}
+	resp := call.resp
+	defer resp.Body.Close()
+	h.l.Info(resp.Status, next)
+
+	result.StatusCode = resp.StatusCode
+	js := &JSONResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(js); err != nil {
+		result.Error = "not a minimal-service response"
+		return []UpstreamResult{result}
+	}
+	result.ServedBy = js.ServedBy
+
+	return append([]UpstreamResult{result}, js.Hops...)
+}
+
+// newUpstreamRequest ... the request to the endpoint of the bounce payload,
+// a plain GET unless method, headers, query or body are given
+func newUpstreamRequest(r *http.Request, jp *JSONPost) (*http.Request, error) {
+	method := strings.ToUpper(jp.Method)
+	if len(method) == 0 {
+		method = http.MethodGet
+	}
+
+	endpoint := jp.Endpoint
+	if len(jp.Query) != 0 {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		query := u.Query()
+		for key, value := range jp.Query {
+			query.Set(key, value)
+		}
+		u.RawQuery = query.Encode()
+		endpoint = u.String()
+	}
+
+	var body io.Reader
+	if len(jp.Body) != 0 {
+		body = strings.NewReader(jp.Body)
+	}
+	req, err := http.NewRequest(method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+
+	forwardHeaders(req, r, jp.Forward)
+	for key, value := range jp.Headers {
+		if http.CanonicalHeaderKey(key) == "Host" {
+			req.Host = value
+			continue
+		}
+		req.Header.Set(key, value)
+	}
+
+	return req, nil
+}
+
+// forwardHeaders ... copy the named headers from the inbound request r to req
+func forwardHeaders(req *http.Request, r *http.Request, names []string) {
+	for _, name := range names {
+		for _, value := range r.Header.Values(name) {
+			req.Header.Add(name, value)
+		}
+	}
+}
+
+// nestedServe ... answer with the response of this instance and the one of the upstream nested
+func (h *Data) nestedServe(rw http.ResponseWriter, r *http.Request, st *time.Time, body []byte, call *upstreamCall) error {
+	resp := call.resp
+	maxBody, _ := strconv.Atoi(h.envs.Get("BOUNCE_MAX_BODY"))
+	if maxBody < 0 {
+		maxBody = 0
+	}
+	upstreamBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(maxBody)+1))
+	if err != nil {
+		h.l.Error("Upstream body,", err.Error())
+	}
+
+	upstream := &UpstreamResponse{
+		StatusCode: resp.StatusCode,
+		Headers:    map[string]string{},
+		Attempts:   call.attempts,
+		Breaker:    call.breaker,
+	}
+	for key := range resp.Header {
+		upstream.Headers[key] = resp.Header.Get(key)
+	}
+	if len(upstreamBody) > maxBody {
+		upstreamBody = upstreamBody[:maxBody]
+		upstream.Truncated = true
+	}
+	upstream.Body = string(upstreamBody)
+
+	return h.answerBounce(rw, r, st, body, http.StatusOK, func(js *JSONResponse) {
+		js.Upstream = upstream
+	})
+}
+
+// transparentServe ... proxy the upstream response as it is
+func (h *Data) transparentServe(rw http.ResponseWriter, r *http.Request, resp *http.Response) error {
+	rw.Header().Del("Content-Type")
+	for key, values := range resp.Header {
+		if contains(hopByHopHeaders, key) {
+			continue
+		}
+		rw.Header()[key] = values
+	}
+	rw.WriteHeader(resp.StatusCode)
+	_, err := io.Copy(rw, resp.Body)
+
+	h.execTracing(r.Context(), resp.StatusCode, http.StatusText(resp.StatusCode), map[string]string{
+		"Upstream":   resp.Request.URL.String(),
+		"StatusCode": strconv.Itoa(resp.StatusCode),
+	})
+
+	return err
+}
+
+func validResponseMode(value string) error {
+	if len(value) != 0 && !contains(responseModes, value) {
+		return fmt.Errorf("unknown response mode %s", value)
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+)
+
+// errBreakerOpen ... the upstream is not called while its circuit breaker is open
+var errBreakerOpen = errors.New("circuit breaker open")
+
+// retryPolicy ... how the bounce client calls an upstream, from the BOUNCE_* envs
+// overridden by the bounce payload
+type retryPolicy struct {
+	timeout    time.Duration
+	overall    time.Duration
+	retries    int
+	backoff    time.Duration
+	backoffMax time.Duration
+	retryOn    []int
+}
+
+// upstreamCall ... outcome of the calls to an upstream, the response is the one of the last attempt
+type upstreamCall struct {
+	resp     *http.Response
+	attempts int
+	breaker  string
+	err      error
+}
+
+// retryPolicy ... the policy of the bounce client for the payload
+func (h *Data) retryPolicy(jp *JSONPost) (retryPolicy, error) {
+	ms := func(payload int, env string) time.Duration {
+		if payload == 0 {
+			payload, _ = strconv.Atoi(h.envs.Get(env))
+		}
+		return time.Duration(payload) * time.Millisecond
+	}
+
+	policy := retryPolicy{
+		timeout:    ms(jp.TimeoutMs, "BOUNCE_TIMEOUT_MS"),
+		overall:    ms(jp.OverallTimeoutMs, "BOUNCE_OVERALL_TIMEOUT_MS"),
+		backoff:    ms(jp.BackoffMs, "BOUNCE_BACKOFF_MS"),
+		backoffMax: ms(0, "BOUNCE_BACKOFF_MAX_MS"),
+		retryOn:    jp.RetryOn,
+	}
+	if jp.Retries != nil {
+		policy.retries = *jp.Retries
+	} else {
+		policy.retries, _ = strconv.Atoi(h.envs.Get("BOUNCE_RETRIES"))
+	}
+	if policy.retryOn == nil {
+		policy.retryOn, _ = parseStatusList(h.envs.Get("BOUNCE_RETRY_ON"))
+	}
+
+	if policy.timeout < 0 || policy.overall < 0 || policy.backoff < 0 || policy.retries < 0 {
+		return policy, fmt.Errorf("timeouts, backoff and retries can't be negative")
+	}
+
+	return policy, nil
+}
+
+// backoffDelay ... exponential backoff before the retry after attempt, with jitter:
+// a random delay between half and all of it, never over backoffMax
+func (p retryPolicy) backoffDelay(attempt int) time.Duration {
+	delay := p.backoff << (attempt - 1)
+	if p.backoffMax > 0 && (delay > p.backoffMax || delay <= 0) {
+		delay = p.backoffMax
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	return delay/2 + time.Duration(unseeded.Int63n(int64(delay/2)+1))
+}
+
+// callUpstream ... check endpoint accepts connections and send it the request made by newRequest,
+// retrying on errors and retryable status codes, as long as its circuit breaker is not open
+func (h *Data) callUpstream(ctx context.Context, endpoint string, policy retryPolicy, newRequest func() (*http.Request, error)) *upstreamCall {
+	call := &upstreamCall{}
+	// the caller context, its cancellation is not an upstream failure
+	caller := ctx
+
+	if policy.overall > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, policy.overall)
+		// the response body is read after, so the cancel comes with its close
+		defer func() {
+			if call.resp != nil {
+				call.resp.Body = &cancelBody{call.resp.Body, cancel}
+			} else {
+				cancel()
+			}
+		}()
+	}
+
+	key := breakerKey(endpoint)
+	threshold, _ := strconv.Atoi(h.envs.Get("BREAKER_FAILURES"))
+	openFor, _ := strconv.Atoi(h.envs.Get("BREAKER_OPEN_SECONDS"))
+
+	for {
+		allowed, state := breakers.allow(key, threshold, time.Duration(openFor)*time.Second)
+		call.breaker = state
+		if !allowed {
+			call.err = errBreakerOpen
+			return call
+		}
+		call.attempts++
+
+		resp, err := h.attempt(ctx, endpoint, policy.timeout, newRequest)
+		call.resp, call.err = resp, err
+		if err != nil && caller.Err() != nil {
+			call.breaker = breakers.release(key)
+			return call
+		}
+		call.breaker = breakers.record(key, err == nil && resp.StatusCode < 500, threshold)
+
+		retryable := err != nil || containsInt(policy.retryOn, resp.StatusCode)
+		if !retryable || call.attempts > policy.retries || ctx.Err() != nil {
+			return call
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+			call.resp = nil
+		}
+
+		delay := policy.backoffDelay(call.attempts)
+		h.l.Debug(h.envs.Get("DEBUG"), "Retrying", endpoint, "in", delay.String())
+		select {
+		case <-ctx.Done():
+			call.err = ctx.Err()
+			return call
+		case <-time.After(delay):
+		}
+	}
+}
+
+// attempt ... a single call to the upstream, through the mesh for consul:// endpoints, bounded by timeout
+func (h *Data) attempt(ctx context.Context, endpoint string, timeout time.Duration, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	client := http.DefaultClient
+	if isMeshEndpoint(endpoint) {
+		if h.mesh == nil {
+			return nil, errNoMesh
+		}
+		client = &http.Client{Transport: h.mesh}
+	} else if err := h.rawConnect(endpoint); err != nil {
+		return nil, err
+	}
+
+	req, err := newRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	cancel := context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	ctx, span := startClientSpan(ctx, req, h.l, h.envs)
+	defer span.End()
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		cancel()
+		return nil, err
+	}
+	span.SetAttributes(label.Int("http.status_code", resp.StatusCode))
+	resp.Body = &cancelBody{resp.Body, cancel}
+
+	return resp, nil
+}
+
+// cancelBody ... response body that cancels the context of its request when closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// parseStatusList ... parse a list of status codes like "502,503,504"
+func parseStatusList(value string) ([]int, error) {
+	var codes []int
+	for _, elem := range strings.Split(value, ",") {
+		if elem = strings.TrimSpace(elem); len(elem) == 0 {
+			continue
+		}
+		code, err := strconv.Atoi(elem)
+		if err != nil {
+			return nil, err
+		}
+		if code < 100 || code > 599 {
+			return nil, fmt.Errorf("status code %d out of range", code)
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+func containsInt(list []int, n int) bool {
+	for _, value := range list {
+		if value == n {
+			return true
+		}
+	}
+
+	return false
+}
+
+func validStatusList(value string) error {
+	_, err := parseStatusList(value)
+	return err
+}
@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/efbar/minimal-service/config"
+	"github.com/efbar/minimal-service/helpers"
+	"github.com/efbar/minimal-service/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyServer ... an upstream answering with the codes in order, the last one forever after,
+// and sleeping for delay before each answer
+func flakyServer(t *testing.T, delay time.Duration, codes ...int) (*httptest.Server, *int32) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&calls, 1)) - 1
+		if n >= len(codes) {
+			n = len(codes) - 1
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(delay):
+		}
+		rw.WriteHeader(codes[n])
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &calls
+}
+
+func TestBounceRetries(t *testing.T) {
+	retries := func(n int) *int { return &n }
+
+	tt := []struct {
+		name        string
+		codes       []int
+		delay       time.Duration
+		payload     JSONPost
+		envs        map[string]string
+		status      int
+		upstream    int
+		attempts    string
+		maxDuration time.Duration
+	}{
+		{
+			name:     "no retries by default",
+			codes:    []int{503, 200},
+			status:   http.StatusOK,
+			upstream: 503,
+			attempts: "1",
+		},
+		{
+			name:     "success after retries",
+			codes:    []int{503, 502, 200},
+			payload:  JSONPost{Retries: retries(3), BackoffMs: 1},
+			status:   http.StatusOK,
+			upstream: 200,
+			attempts: "3",
+		},
+		{
+			name:     "retries from envs",
+			codes:    []int{504, 200},
+			envs:     map[string]string{"BOUNCE_RETRIES": "1", "BOUNCE_BACKOFF_MS": "1"},
+			status:   http.StatusOK,
+			upstream: 200,
+			attempts: "2",
+		},
+		{
+			name:     "retries exhausted",
+			codes:    []int{503},
+			payload:  JSONPost{Retries: retries(2), BackoffMs: 1},
+			status:   http.StatusOK,
+			upstream: 503,
+			attempts: "3",
+		},
+		{
+			name:     "status not retryable",
+			codes:    []int{500, 200},
+			payload:  JSONPost{Retries: retries(2), BackoffMs: 1},
+			status:   http.StatusOK,
+			upstream: 500,
+			attempts: "1",
+		},
+		{
+			name:     "custom retryable status",
+			codes:    []int{500, 200},
+			payload:  JSONPost{Retries: retries(2), BackoffMs: 1, RetryOn: []int{500}},
+			status:   http.StatusOK,
+			upstream: 200,
+			attempts: "2",
+		},
+		{
+			name:        "attempt timeout",
+			codes:       []int{200},
+			delay:       time.Second,
+			payload:     JSONPost{Retries: retries(1), BackoffMs: 1, TimeoutMs: 50},
+			status:      http.StatusBadGateway,
+			attempts:    "2",
+			maxDuration: 500 * time.Millisecond,
+		},
+		{
+			name:        "overall timeout",
+			codes:       []int{200},
+			delay:       time.Second,
+			payload:     JSONPost{Retries: retries(5), TimeoutMs: 50, OverallTimeoutMs: 120},
+			status:      http.StatusBadGateway,
+			maxDuration: 500 * time.Millisecond,
+		},
+		{
+			name:    "negative retries",
+			codes:   []int{200},
+			payload: JSONPost{Retries: retries(-1)},
+			status:  http.StatusBadRequest,
+		},
+	}
+
+	for _, tr := range tt {
+		handler := setupReqHTTPTest(t)
+		envs := map[string]string{}
+		for key, value := range helpers.ListEnvs {
+			envs[key] = value
+		}
+		for key, value := range tr.envs {
+			envs[key] = value
+		}
+		handler.envs = config.NewStore(envs)
+
+		t.Run(tr.name, func(t *testing.T) {
+			upstream, _ := flakyServer(t, tr.delay, tr.codes...)
+			tr.payload.Rebound = "true"
+			tr.payload.Endpoint = upstream.URL
+			tr.payload.Response = "nested"
+			body, _ := json.Marshal(&tr.payload)
+			rr := httptest.NewRecorder()
+
+			st := time.Now()
+			handler.ServeHTTP(rr, httptest.NewRequest("POST", "/bounce", bytes.NewReader(body)))
+
+			assert.Equal(t, tr.status, rr.Code)
+			if tr.maxDuration != 0 {
+				assert.Less(t, time.Since(st), tr.maxDuration)
+			}
+			if tr.attempts != "" {
+				assert.Equal(t, tr.attempts, rr.Header().Get("X-Bounce-Attempts"))
+			}
+			if tr.status == http.StatusOK {
+				js := &JSONResponse{}
+				assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), js))
+				if assert.NotNil(t, js.Upstream) {
+					assert.Equal(t, tr.upstream, js.Upstream.StatusCode)
+					assert.Equal(t, breakerClosed, js.Upstream.Breaker)
+				}
+			}
+		})
+	}
+}
+
+func TestBounceBreaker(t *testing.T) {
+	l := log.New(os.Stdout,
+		"Test Logger: ",
+		log.Ldate|log.Ltime)
+	logger := &logging.Logger{
+		Logger: l,
+	}
+	breakers.reset("")
+	defer breakers.reset("")
+
+	upstream, calls := flakyServer(t, 0, http.StatusServiceUnavailable)
+	handler := setupReqHTTPTest(t)
+	handler.envs = config.NewStore(map[string]string{
+		"BREAKER_FAILURES":     "2",
+		"BREAKER_OPEN_SECONDS": "60",
+	})
+	body, _ := json.Marshal(&JSONPost{Rebound: "true", Endpoint: upstream.URL})
+
+	states := []string{breakerClosed, breakerOpen, breakerOpen}
+	codes := []int{http.StatusOK, http.StatusOK, http.StatusServiceUnavailable}
+	for i := range states {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("POST", "/bounce", bytes.NewReader(body)))
+
+		assert.Equal(t, codes[i], rr.Code)
+		assert.Equal(t, states[i], rr.Header().Get("X-Bounce-Breaker"))
+	}
+	// the open breaker didn't let the last request go
+	assert.Equal(t, int32(2), atomic.LoadInt32(calls))
+
+	admin := HandlerBreakers(*logger, handler.envs)
+	rr := httptest.NewRecorder()
+	admin.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/breakers", nil))
+	list := []breakerState{}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &list))
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, upstream.URL, list[0].Endpoint)
+		assert.Equal(t, breakerOpen, list[0].State)
+		assert.Equal(t, 2, list[0].Failures)
+	}
+
+	rr = httptest.NewRecorder()
+	admin.ServeHTTP(rr, httptest.NewRequest("DELETE", "/admin/breakers?endpoint="+upstream.URL+"/some/path", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, breakers.list())
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	key := "http://half-open.test:80"
+	defer breakers.reset(key)
+
+	breakers.record(key, false, 1)
+	allowed, state := breakers.allow(key, 1, time.Hour)
+	assert.False(t, allowed)
+	assert.Equal(t, breakerOpen, state)
+
+	// after the open period a single trial goes
+	allowed, state = breakers.allow(key, 1, 0)
+	assert.True(t, allowed)
+	assert.Equal(t, breakerHalfOpen, state)
+	allowed, _ = breakers.allow(key, 1, 0)
+	assert.False(t, allowed)
+
+	assert.Equal(t, breakerOpen, breakers.record(key, false, 1))
+	breakers.allow(key, 1, 0)
+	assert.Equal(t, breakerClosed, breakers.record(key, true, 1))
+
+	// a trial cancelled by the caller lets another trial go
+	breakers.record(key, false, 1)
+	breakers.allow(key, 1, 0)
+	assert.Equal(t, breakerHalfOpen, breakers.release(key))
+	allowed, _ = breakers.allow(key, 1, 0)
+	assert.True(t, allowed)
+}
+
+func TestFanoutBreaker(t *testing.T) {
+	breakers.reset("")
+	defer breakers.reset("")
+
+	fast, _ := flakyServer(t, 0, http.StatusOK)
+	slow, _ := flakyServer(t, 200*time.Millisecond, http.StatusOK)
+	handler := setupReqHTTPTest(t)
+	handler.envs = config.NewStore(map[string]string{
+		"BREAKER_FAILURES":     "1",
+		"BREAKER_OPEN_SECONDS": "60",
+	})
+	body, _ := json.Marshal(&JSONPost{Rebound: "true", Endpoints: []string{fast.URL, slow.URL}, Mode: "first"})
+
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("POST", "/bounce", bytes.NewReader(body)))
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+
+	// the slow call cancelled once the outcome is known is not a failure
+	for _, breaker := range breakers.list() {
+		assert.Equal(t, breakerClosed, breaker.State, breaker.Endpoint)
+		assert.Equal(t, 0, breaker.Failures, breaker.Endpoint)
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	policy := retryPolicy{backoff: 100 * time.Millisecond, backoffMax: time.Second}
+
+	for attempt, max := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		max *= time.Millisecond
+		for i := 0; i < 20; i++ {
+			delay := policy.backoffDelay(attempt + 1)
+			assert.GreaterOrEqual(t, delay, max/2)
+			assert.LessOrEqual(t, delay, max)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/efbar/minimal-service/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// bounceServer ... a minimal-service listening on a local port
+func bounceServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(setupReqHTTPTest(t))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestBounceHops(t *testing.T) {
+	first, second, third := bounceServer(t), bounceServer(t), bounceServer(t)
+
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	down := "http://" + closed.Addr().String() + "/bounce"
+	closed.Close()
+
+	tt := []struct {
+		name      string
+		hops      []string
+		status    int
+		endpoints []string
+		codes     []int
+		failing   string
+	}{
+		{
+			name:   "end of the chain",
+			hops:   []string{},
+			status: http.StatusOK,
+		},
+		{
+			name:      "three hops",
+			hops:      []string{first.URL + "/bounce", second.URL + "/bounce", third.URL + "/bounce"},
+			status:    http.StatusOK,
+			endpoints: []string{first.URL + "/bounce", second.URL + "/bounce", third.URL + "/bounce"},
+			codes:     []int{http.StatusOK, http.StatusOK, http.StatusOK},
+		},
+		{
+			name:      "unreachable hop in the middle",
+			hops:      []string{first.URL + "/bounce", down, third.URL + "/bounce"},
+			status:    http.StatusBadGateway,
+			endpoints: []string{first.URL + "/bounce", down},
+			codes:     []int{http.StatusBadGateway, 0},
+			failing:   down,
+		},
+	}
+
+	for _, tr := range tt {
+		handler := setupReqHTTPTest(t)
+
+		t.Run(tr.name, func(t *testing.T) {
+			body, _ := json.Marshal(&JSONPost{Rebound: "true", Hops: tr.hops})
+			req := httptest.NewRequest("POST", "/bounce", bytes.NewReader(body))
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tr.status, rr.Code)
+			js := &JSONResponse{}
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), js))
+			assert.Equal(t, tr.status, js.StatusCode)
+			if assert.Len(t, js.Hops, len(tr.endpoints)) {
+				for i, hop := range js.Hops {
+					assert.Equal(t, tr.endpoints[i], hop.Endpoint)
+					assert.Equal(t, tr.codes[i], hop.StatusCode)
+					if hop.Endpoint == tr.failing {
+						assert.NotEmpty(t, hop.Error)
+					} else {
+						assert.NotEmpty(t, hop.ServedBy)
+						assert.Empty(t, hop.Error)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestBounceRequest(t *testing.T) {
+	var received *http.Request
+	var receivedBody string
+	upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ := ioutil.ReadAll(r.Body)
+		receivedBody = string(body)
+	}))
+	defer upstream.Close()
+
+	tt := []struct {
+		name     string
+		payload  JSONPost
+		inbound  map[string]string
+		method   string
+		headers  map[string]string
+		host     string
+		rawQuery string
+		body     string
+	}{
+		{
+			name:    "plain GET",
+			payload: JSONPost{Rebound: "true", Endpoint: upstream.URL + "/path"},
+			method:  "GET",
+		},
+		{
+			name: "method, headers, query and body",
+			payload: JSONPost{
+				Rebound:  "true",
+				Endpoint: upstream.URL + "/path?a=1",
+				Method:   "put",
+				Headers:  map[string]string{"X-Canary": "true", "Host": "reviews.internal"},
+				Query:    map[string]string{"b": "2"},
+				Body:     `{"stars":5}`,
+			},
+			method:   "PUT",
+			headers:  map[string]string{"X-Canary": "true"},
+			host:     "reviews.internal",
+			rawQuery: "a=1&b=2",
+			body:     `{"stars":5}`,
+		},
+		{
+			name: "forwarded headers",
+			payload: JSONPost{
+				Rebound:  "true",
+				Endpoint: upstream.URL,
+				Forward:  []string{"Authorization", "x-request-id"},
+				Headers:  map[string]string{"X-Request-ID": "overridden"},
+			},
+			inbound: map[string]string{
+				"Authorization": "Bearer abc",
+				"X-Request-ID":  "42",
+				"Cookie":        "not forwarded",
+			},
+			method: "GET",
+			headers: map[string]string{
+				"Authorization": "Bearer abc",
+				"X-Request-ID":  "overridden",
+				"Cookie":        "",
+			},
+		},
+	}
+
+	for _, tr := range tt {
+		handler := setupReqHTTPTest(t)
+
+		t.Run(tr.name, func(t *testing.T) {
+			received, receivedBody = nil, ""
+			body, _ := json.Marshal(&tr.payload)
+			req := httptest.NewRequest("POST", "/bounce", bytes.NewReader(body))
+			for key, value := range tr.inbound {
+				req.Header.Set(key, value)
+			}
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+			if assert.NotNil(t, received) {
+				assert.Equal(t, tr.method, received.Method)
+				assert.Equal(t, tr.rawQuery, received.URL.RawQuery)
+				assert.Equal(t, tr.body, receivedBody)
+				for key, value := range tr.headers {
+					assert.Equal(t, value, received.Header.Get(key), key)
+				}
+				if tr.host != "" {
+					assert.Equal(t, tr.host, received.Host)
+				}
+			}
+		})
+	}
+}
+
+func TestBounceBadMethod(t *testing.T) {
+	handler := setupReqHTTPTest(t)
+	body, _ := json.Marshal(&JSONPost{Rebound: "true", Endpoint: bounceServer(t).URL, Method: "NOT A METHOD"})
+	rr := httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/bounce", bytes.NewReader(body)))
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestBounceFanout(t *testing.T) {
+	ok := bounceServer(t).URL
+	// failing slowly, so the quorum can't be given up before ok answers
+	failing := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	down := "http://" + closed.Addr().String()
+	closed.Close()
+
+	tt := []struct {
+		name        string
+		payload     JSONPost
+		status      int
+		succeeded   []bool
+		maxDuration time.Duration
+	}{
+		{
+			name:      "all succeeded",
+			payload:   JSONPost{Endpoints: []string{ok, ok, ok}},
+			status:    http.StatusOK,
+			succeeded: []bool{true, true, true},
+		},
+		{
+			name:      "all with a failure",
+			payload:   JSONPost{Endpoints: []string{ok, failing.URL}, Mode: "all"},
+			status:    http.StatusBadGateway,
+			succeeded: []bool{true, false},
+		},
+		{
+			name:        "first success cancels the others",
+			payload:     JSONPost{Endpoints: []string{slow.URL, ok}, Mode: "first"},
+			status:      http.StatusOK,
+			succeeded:   []bool{false, true},
+			maxDuration: 500 * time.Millisecond,
+		},
+		{
+			name:      "quorum reached",
+			payload:   JSONPost{Endpoints: []string{ok, failing.URL, ok}, Mode: "quorum", Quorum: 2},
+			status:    http.StatusOK,
+			succeeded: []bool{true, false, true},
+		},
+		{
+			name:      "majority quorum not reached",
+			payload:   JSONPost{Endpoints: []string{ok, failing.URL, down}, Mode: "quorum"},
+			status:    http.StatusBadGateway,
+			succeeded: []bool{true, false, false},
+		},
+		{
+			name:        "deadline over",
+			payload:     JSONPost{Endpoints: []string{ok, slow.URL}, DeadlineMs: 100},
+			status:      http.StatusGatewayTimeout,
+			succeeded:   []bool{true, false},
+			maxDuration: 500 * time.Millisecond,
+		},
+		{
+			name:    "quorum too big",
+			payload: JSONPost{Endpoints: []string{ok}, Mode: "quorum", Quorum: 2},
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "unknown mode",
+			payload: JSONPost{Endpoints: []string{ok}, Mode: "some"},
+			status:  http.StatusBadRequest,
+		},
+	}
+
+	for _, tr := range tt {
+		handler := setupReqHTTPTest(t)
+
+		t.Run(tr.name, func(t *testing.T) {
+			tr.payload.Rebound = "true"
+			body, _ := json.Marshal(&tr.payload)
+			req := httptest.NewRequest("POST", "/bounce", bytes.NewReader(body))
+			rr := httptest.NewRecorder()
+
+			st := time.Now()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tr.status, rr.Code)
+			if tr.maxDuration != 0 {
+				assert.Less(t, time.Since(st), tr.maxDuration)
+			}
+			if tr.status == http.StatusBadRequest {
+				return
+			}
+			js := &JSONResponse{}
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), js))
+			if assert.Len(t, js.Targets, len(tr.succeeded)) {
+				for i, target := range js.Targets {
+					assert.Equal(t, tr.payload.Endpoints[i], target.Endpoint)
+					assert.Equal(t, tr.succeeded[i], target.succeeded(), target.Endpoint)
+					assert.Greater(t, target.Duration, 0.0)
+				}
+			}
+		})
+	}
+}
+
+func TestBounceResponseModes(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("X-Upstream", "reviews")
+		rw.Header().Set("Content-Type", "text/plain")
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		rw.Write([]byte("down for maintenance"))
+	}))
+	defer upstream.Close()
+
+	tt := []struct {
+		name      string
+		mode      string
+		maxBody   string
+		status    int
+		body      string
+		truncated bool
+	}{
+		{
+			name:   "legacy",
+			status: http.StatusOK,
+		},
+		{
+			name:    "nested",
+			mode:    "nested",
+			maxBody: "4096",
+			status:  http.StatusOK,
+			body:    "down for maintenance",
+		},
+		{
+			name:      "nested and truncated",
+			mode:      "nested",
+			maxBody:   "4",
+			status:    http.StatusOK,
+			body:      "down",
+			truncated: true,
+		},
+		{
+			name:   "transparent",
+			mode:   "transparent",
+			status: http.StatusServiceUnavailable,
+			body:   "down for maintenance",
+		},
+		{
+			name:   "unknown mode",
+			mode:   "opaque",
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, tr := range tt {
+		handler := setupReqHTTPTest(t)
+		handler.envs = config.NewStore(map[string]string{"BOUNCE_MAX_BODY": tr.maxBody})
+
+		t.Run(tr.name, func(t *testing.T) {
+			body, _ := json.Marshal(&JSONPost{Rebound: "true", Endpoint: upstream.URL, Response: tr.mode})
+			req := httptest.NewRequest("POST", "/bounce", bytes.NewReader(body))
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tr.status, rr.Code)
+			switch tr.mode {
+			case "":
+				js := &JSONResponse{}
+				assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), js))
+				assert.Equal(t, "503 Service Unavailable", js.Body)
+				assert.Nil(t, js.Upstream)
+			case "nested":
+				js := &JSONResponse{}
+				assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), js))
+				if assert.NotNil(t, js.Upstream) {
+					assert.Equal(t, http.StatusServiceUnavailable, js.Upstream.StatusCode)
+					assert.Equal(t, "reviews", js.Upstream.Headers["X-Upstream"])
+					assert.Equal(t, tr.body, js.Upstream.Body)
+					assert.Equal(t, tr.truncated, js.Upstream.Truncated)
+				}
+			case "transparent":
+				assert.Equal(t, tr.body, rr.Body.String())
+				assert.Equal(t, "reviews", rr.Header().Get("X-Upstream"))
+				assert.Equal(t, "text/plain", rr.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/efbar/minimal-service/config"
+	"github.com/efbar/minimal-service/logging"
+)
+
+// circuit breaker states
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// breakerState ... circuit breaker of an upstream
+type breakerState struct {
+	Endpoint string    `json:"endpoint"`
+	State    string    `json:"state"`
+	Failures int       `json:"failures"`
+	OpenedAt time.Time `json:"openedAt,omitempty"`
+
+	trial bool
+}
+
+// breakerRegistry ... circuit breakers of the upstreams, shared by all the handlers
+type breakerRegistry struct {
+	mu       sync.Mutex
+	breakers map[string]*breakerState
+}
+
+var breakers = &breakerRegistry{
+	breakers: map[string]*breakerState{},
+}
+
+// breakerKey ... breakers are per scheme, host and port
+func breakerKey(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil || len(u.Host) == 0 {
+		return endpoint
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// get ... the breaker of the key, created closed
+func (b *breakerRegistry) get(key string) *breakerState {
+	breaker, ok := b.breakers[key]
+	if !ok {
+		breaker = &breakerState{Endpoint: key, State: breakerClosed}
+		b.breakers[key] = breaker
+	}
+	return breaker
+}
+
+// allow ... true if a request can go to the upstream of key. An open breaker lets
+// a single trial request go after openFor, breakers are off if threshold is 0
+func (b *breakerRegistry) allow(key string, threshold int, openFor time.Duration) (bool, string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	breaker := b.get(key)
+	if threshold <= 0 {
+		return true, breaker.State
+	}
+
+	switch breaker.State {
+	case breakerOpen:
+		if time.Since(breaker.OpenedAt) < openFor {
+			return false, breaker.State
+		}
+		breaker.State = breakerHalfOpen
+		breaker.trial = true
+		return true, breaker.State
+	case breakerHalfOpen:
+		if breaker.trial {
+			return false, breaker.State
+		}
+		breaker.trial = true
+	}
+
+	return true, breaker.State
+}
+
+// record ... count the outcome of a request to the upstream of key and return the new state,
+// threshold failures in a row, or a failed trial, open the breaker
+func (b *breakerRegistry) record(key string, success bool, threshold int) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	breaker := b.get(key)
+	breaker.trial = false
+	if success {
+		breaker.State = breakerClosed
+		breaker.Failures = 0
+		return breaker.State
+	}
+
+	breaker.Failures++
+	if threshold > 0 && (breaker.State == breakerHalfOpen || breaker.Failures >= threshold) {
+		breaker.State = breakerOpen
+		breaker.OpenedAt = time.Now()
+	}
+
+	return breaker.State
+}
+
+// release ... a request to the upstream of key was cancelled by the caller, it is not counted
+// but a half-open breaker can let another trial request go
+func (b *breakerRegistry) release(key string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	breaker := b.get(key)
+	breaker.trial = false
+
+	return breaker.State
+}
+
+// list ... all the breakers, sorted by endpoint
+func (b *breakerRegistry) list() []breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	list := make([]breakerState, 0, len(b.breakers))
+	for _, breaker := range b.breakers {
+		list = append(list, *breaker)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Endpoint < list[j].Endpoint })
+
+	return list
+}
+
+// reset ... drop the breaker of the endpoint, or all of them if endpoint is empty
+func (b *breakerRegistry) reset(endpoint string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := 0
+	for key := range b.breakers {
+		if len(endpoint) == 0 || key == breakerKey(endpoint) {
+			delete(b.breakers, key)
+			n++
+		}
+	}
+
+	return n
+}
+
+// Breakers ...
+type Breakers struct {
+	log  logging.Logger
+	envs *config.Store
+}
+
+// HandlerBreakers ...
+func HandlerBreakers(l logging.Logger, envs *config.Store) *Breakers {
+	return &Breakers{
+		log:  l,
+		envs: envs,
+	}
+}
+
+// ServeHTTP ... GET lists the circuit breakers, DELETE resets them, all or only the one of ?endpoint=
+func (h *Breakers) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	h.log.Debug(h.envs.Get("DEBUG"), r.Method, "on", r.URL.String(), "from", r.RemoteAddr)
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodDelete:
+		n := breakers.reset(r.URL.Query().Get("endpoint"))
+		h.log.Info("Admin:", strconv.Itoa(n), "circuit breakers reset by", r.RemoteAddr)
+	default:
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(breakers.list()); err != nil {
+		h.log.Error("error encoding json", err.Error())
+	}
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// discardModes ... what happens to the connection of a discarded request
+var discardModes = []string{
+	"empty",      // empty 200 response, net/http default
+	"reset",      // TCP RST right away
+	"hang",       // accept and hang until the client gives up or HANG_MAX seconds
+	"truncate",   // send headers and close in the middle of the body
+	"bad-length", // send the whole body but a longer Content-Length
+	"drip",       // send the response at DRIP_RATE bytes per second
+}
+
+// connectionFault ... break the connection of a discarded request as asked by DISCARD_MODE
+func (h *Data) connectionFault(rw http.ResponseWriter, r *http.Request, st *time.Time, envs map[string]string) {
+	mode := envs["DISCARD_MODE"]
+	h.l.Debug(envs["DEBUG"], "Discard mode", mode)
+
+	// HTTP/2 connections, like the HTTPS and Connect ones, are shared by many
+	// requests and can't be taken over
+	if _, ok := rw.(http.Hijacker); !ok && mode != "empty" && mode != "drip" {
+		h.l.Error("Connection fault", mode, "needs HTTP/1.1, the request is", r.Proto)
+		ErrorJSON(rw, fmt.Sprintf("Connection fault %s needs HTTP/1.1", mode), http.StatusHTTPVersionNotSupported)
+		return
+	}
+
+	switch mode {
+	case "reset":
+		h.hijack(rw, func(conn net.Conn, _ io.Writer) {
+			if tlsConn, ok := conn.(*tls.Conn); ok {
+				conn = tlsConn.NetConn()
+			}
+			if tcpConn, ok := conn.(*net.TCPConn); ok {
+				tcpConn.SetLinger(0)
+			}
+		})
+	case "hang":
+		hangMax, _ := strconv.Atoi(envs["HANG_MAX"])
+		h.hijack(rw, func(conn net.Conn, _ io.Writer) {
+			var deadline time.Time
+			if hangMax > 0 {
+				deadline = time.Now().Add(time.Duration(hangMax) * time.Second)
+			}
+			conn.SetDeadline(deadline)
+			io.Copy(ioutil.Discard, conn)
+		})
+	case "truncate", "bad-length":
+		resp := &bufferedResponse{header: http.Header{}}
+		h.serve(resp, r, st)
+		body := resp.body.Bytes()
+		length := len(body)
+		if mode == "truncate" {
+			body = body[:len(body)/2]
+		} else {
+			length = 2*length + 1
+		}
+		h.hijack(rw, func(_ net.Conn, w io.Writer) {
+			resp.header.Set("Content-Length", strconv.Itoa(length))
+			fmt.Fprintf(w, "HTTP/1.1 %d %s\r\n", resp.status(), http.StatusText(resp.status()))
+			resp.header.Write(w)
+			io.WriteString(w, "\r\n")
+			w.Write(body)
+		})
+	case "drip":
+		rate, _ := strconv.Atoi(envs["DRIP_RATE"])
+		if rate <= 0 {
+			rate = 1
+		}
+		resp := &bufferedResponse{header: http.Header{}}
+		h.serve(resp, r, st)
+		for key, values := range resp.header {
+			rw.Header()[key] = values
+		}
+		rw.Header().Set("Content-Length", strconv.Itoa(resp.body.Len()))
+		rw.WriteHeader(resp.status())
+		drip(rw, resp.body.Bytes(), rate)
+	}
+}
+
+// hijack ... take over the connection, send what fault writes and close it
+func (h *Data) hijack(rw http.ResponseWriter, fault func(conn net.Conn, w io.Writer)) {
+	conn, bufrw, err := rw.(http.Hijacker).Hijack()
+	if err != nil {
+		h.l.Error("Hijack error:", err.Error())
+		return
+	}
+	defer conn.Close()
+
+	fault(conn, bufrw)
+	bufrw.Flush()
+}
+
+// drip ... write body at rate bytes per second, in chunks every 100 milliseconds
+func drip(rw http.ResponseWriter, body []byte, rate int) {
+	chunk := rate / 10
+	if chunk == 0 {
+		chunk = 1
+	}
+	interval := time.Second * time.Duration(chunk) / time.Duration(rate)
+
+	flusher, _ := rw.(http.Flusher)
+	for len(body) > 0 {
+		n := chunk
+		if n > len(body) {
+			n = len(body)
+		}
+		if _, err := rw.Write(body[:n]); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		body = body[n:]
+		if len(body) > 0 {
+			time.Sleep(interval)
+		}
+	}
+}
+
+// bufferedResponse ... keep a whole response in memory, to be sent broken later
+type bufferedResponse struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponse) WriteHeader(code int) {
+	if b.code == 0 {
+		b.code = code
+	}
+}
+
+func (b *bufferedResponse) status() int {
+	if b.code == 0 {
+		return http.StatusOK
+	}
+	return b.code
+}
+
+func validDiscardMode(value string) error {
+	if !contains(discardModes, value) {
+		return fmt.Errorf("unknown discard mode %s", value)
+	}
+	return nil
+}
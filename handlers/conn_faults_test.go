@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/efbar/minimal-service/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectionFaults(t *testing.T) {
+	tt := []struct {
+		name    string
+		headers map[string]string
+		envs    map[string]string
+		status  int
+		err     bool
+		bodyErr error
+		minTime time.Duration
+	}{
+		{
+			name:   "empty response",
+			envs:   map[string]string{"DISCARD_QUOTA": "100", "DISCARD_MODE": "empty"},
+			status: http.StatusOK,
+		},
+		{
+			name:    "connection reset",
+			headers: map[string]string{"X-Fault-Connection": "reset"},
+			err:     true,
+		},
+		{
+			name:    "hang until the client times out",
+			headers: map[string]string{"X-Fault-Connection": "hang"},
+			err:     true,
+			minTime: 200 * time.Millisecond,
+		},
+		{
+			name:    "hang for HANG_MAX seconds",
+			envs:    map[string]string{"DISCARD_QUOTA": "100", "DISCARD_MODE": "hang", "HANG_MAX": "1"},
+			err:     true,
+			minTime: 200 * time.Millisecond,
+		},
+		{
+			name:    "truncated body",
+			headers: map[string]string{"X-Fault-Connection": "truncate"},
+			status:  http.StatusOK,
+			bodyErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "lying Content-Length",
+			headers: map[string]string{"X-Fault-Connection": "bad-length"},
+			status:  http.StatusOK,
+			bodyErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "slow drip",
+			headers: map[string]string{"X-Fault-Connection": "drip", "X-Fault-Drip-Rate": "2000"},
+			status:  http.StatusOK,
+			minTime: 100 * time.Millisecond,
+		},
+		{
+			name:    "connection fault wins over rejection",
+			headers: map[string]string{"X-Fault-Connection": "truncate", "X-Fault-Status": "503"},
+			status:  http.StatusOK,
+			bodyErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "not valid mode is ignored",
+			headers: map[string]string{"X-Fault-Connection": "explode"},
+			status:  http.StatusOK,
+		},
+	}
+
+	for _, tr := range tt {
+		t.Run(tr.name, func(t *testing.T) {
+			handler := setupReqHTTPTest(t)
+			envs := map[string]string{
+				"DELAY_MAX": "0",
+				"TRACING":   "0",
+			}
+			for key, value := range tr.envs {
+				envs[key] = value
+			}
+			handler.envs = config.NewStore(envs)
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			client := &http.Client{Timeout: 200 * time.Millisecond}
+			if tr.name == "slow drip" {
+				client.Timeout = 5 * time.Second
+			}
+			req, _ := http.NewRequest("GET", server.URL, nil)
+			for key, value := range tr.headers {
+				req.Header.Set(key, value)
+			}
+
+			st := time.Now()
+			res, err := client.Do(req)
+			if tr.err {
+				assert.Error(t, err)
+				assert.GreaterOrEqual(t, time.Since(st), tr.minTime)
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer res.Body.Close()
+
+			assert.Equal(t, tr.status, res.StatusCode)
+			_, err = ioutil.ReadAll(res.Body)
+			assert.Equal(t, tr.bodyErr, err)
+			assert.GreaterOrEqual(t, time.Since(st), tr.minTime)
+		})
+	}
+}
+
+func TestConnectionFaultNotHijackable(t *testing.T) {
+	handler := setupReqHTTPTest(t)
+	handler.envs = config.NewStore(map[string]string{
+		"DISCARD_QUOTA": "100",
+		"DISCARD_MODE":  "reset",
+	})
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	assert.Equal(t, http.StatusHTTPVersionNotSupported, rr.Code)
+}
+
+func TestConnectionFaultsTLS(t *testing.T) {
+	tt := []struct {
+		name   string
+		http2  bool
+		mode   string
+		status int
+		err    bool
+	}{
+		{
+			name: "HTTP/1.1, reset",
+			mode: "reset",
+			err:  true,
+		},
+		{
+			name:   "HTTP/2, reset",
+			http2:  true,
+			mode:   "reset",
+			status: http.StatusHTTPVersionNotSupported,
+		},
+		{
+			name:   "HTTP/2, truncate",
+			http2:  true,
+			mode:   "truncate",
+			status: http.StatusHTTPVersionNotSupported,
+		},
+		{
+			name:   "HTTP/2, drip",
+			http2:  true,
+			mode:   "drip",
+			status: http.StatusOK,
+		},
+	}
+
+	for _, tr := range tt {
+		t.Run(tr.name, func(t *testing.T) {
+			handler := setupReqHTTPTest(t)
+			handler.envs = config.NewStore(map[string]string{
+				"DELAY_MAX": "0",
+				"TRACING":   "0",
+				"DRIP_RATE": "100000",
+			})
+			server := httptest.NewUnstartedServer(handler)
+			server.EnableHTTP2 = tr.http2
+			server.StartTLS()
+			defer server.Close()
+
+			client := server.Client()
+			client.Timeout = time.Second
+			req, _ := http.NewRequest("GET", server.URL, nil)
+			req.Header.Set("X-Fault-Connection", tr.mode)
+
+			res, err := client.Do(req)
+			if tr.err {
+				assert.Error(t, err)
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer res.Body.Close()
+
+			assert.Equal(t, 2, res.ProtoMajor)
+			assert.Equal(t, tr.status, res.StatusCode)
+			_, err = ioutil.ReadAll(res.Body)
+			assert.NoError(t, err)
+		})
+	}
+}
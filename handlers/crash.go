@@ -3,33 +3,129 @@ package handlers
 import (
 	"net/http"
 	"os"
+	"strconv"
+	"sync"
+	"time"
 
+	"github.com/efbar/minimal-service/config"
 	"github.com/efbar/minimal-service/logging"
 )
 
+// exit ... how the process exits, replaced in tests
+var exit = os.Exit
+
+// freeze ... held for reading by every request, a deadlock takes it for writing and never gives it back
+var freeze sync.RWMutex
+
+// oomChunk ... bytes allocated at a time while running out of memory
+const oomChunk = 64 << 20
+
 // Crash ...
 type Crash struct {
 	log  logging.Logger
-	envs map[string]string
+	envs *config.Store
 }
 
 // HandlerCrash ...
-func HandlerCrash(l logging.Logger, envs map[string]string) *Crash {
+func HandlerCrash(l logging.Logger, envs *config.Store) *Crash {
 	return &Crash{
 		log:  l,
 		envs: envs,
 	}
 }
 
-// ServeHTTP ...
+// Freezable ... serve next, unless the service has been deadlocked
+func Freezable(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		freeze.RLock()
+		defer freeze.RUnlock()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// ServeHTTP ... crash the service as asked by the mode query parameter
 func (h *Crash) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
-	h.log.Debug(h.envs["DEBUG"], r.Method, "on", r.URL.String(), "from", r.RemoteAddr)
+	h.log.Debug(h.envs.Get("DEBUG"), r.Method, "on", r.URL.String(), "from", r.RemoteAddr)
 
-	if r.Method == http.MethodGet {
-		h.log.Debug(h.envs["DEBUG"], "Crashing")
-		os.Exit(137)
-	} else {
+	if r.Method != http.MethodGet {
 		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
 	}
 
+	query := r.URL.Query()
+	mode := query.Get("mode")
+	if len(mode) == 0 {
+		mode = "exit"
+	}
+
+	code := 137
+	if value := query.Get("code"); len(value) != 0 {
+		var err error
+		code, err = strconv.Atoi(value)
+		if err != nil || code < 0 || code > 255 {
+			ErrorJSON(rw, "code must be between 0 and 255", http.StatusBadRequest)
+			return
+		}
+	}
+
+	h.log.Info("Crashing, mode", mode, "requested by", r.RemoteAddr)
+
+	switch mode {
+	case "exit":
+		exit(code)
+	case "panic":
+		// net/http recovers panics in handlers, this one has to happen elsewhere
+		go func() {
+			panic("crash requested by " + r.RemoteAddr)
+		}()
+	case "sigkill":
+		self, err := os.FindProcess(os.Getpid())
+		if err == nil {
+			err = self.Kill()
+		}
+		if err != nil {
+			ErrorJSON(rw, err.Error(), http.StatusInternalServerError)
+		}
+	case "delayed":
+		after, err := strconv.Atoi(query.Get("after"))
+		if err != nil || after <= 0 {
+			ErrorJSON(rw, "after must be a number of seconds", http.StatusBadRequest)
+			return
+		}
+		time.AfterFunc(time.Duration(after)*time.Second, func() {
+			h.log.Info("Crashing after", strconv.Itoa(after), "seconds")
+			exit(code)
+		})
+		rw.WriteHeader(http.StatusAccepted)
+		rw.Write([]byte("Crashing in " + strconv.Itoa(after) + "s"))
+	case "after-response":
+		body := []byte("Crashing")
+		rw.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(body)
+		if flusher, ok := rw.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		exit(code)
+	case "oom":
+		go func() {
+			var hog [][]byte
+			for {
+				chunk := make([]byte, oomChunk)
+				// touch every page, or they won't be resident
+				for i := 0; i < len(chunk); i += os.Getpagesize() {
+					chunk[i] = 1
+				}
+				hog = append(hog, chunk)
+			}
+		}()
+		rw.WriteHeader(http.StatusAccepted)
+		rw.Write([]byte("Allocating memory until out of memory"))
+	case "deadlock":
+		// under Freezable this request holds a read lock too, so it waits forever
+		// and every request coming after it waits behind
+		freeze.Lock()
+	default:
+		ErrorJSON(rw, "unknown crash mode "+mode, http.StatusBadRequest)
+	}
 }
@@ -2,10 +2,13 @@ package handlers
 
 import (
 	"log"
+	"net/http"
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/efbar/minimal-service/config"
 	"github.com/efbar/minimal-service/helpers"
 	"github.com/efbar/minimal-service/logging"
 	"github.com/stretchr/testify/assert"
@@ -21,7 +24,8 @@ func setupCrashTest(t *testing.T) *Data {
 
 	return &Data{
 		*logger,
-		helpers.ListEnvs,
+		config.NewStore(helpers.ListEnvs),
+		nil,
 	}
 }
 
@@ -54,3 +58,138 @@ func TestCrashResp(t *testing.T) {
 		}
 	}
 }
+
+func TestCrashModes(t *testing.T) {
+	l := log.New(os.Stdout,
+		"Test Logger: ",
+		log.Ldate|log.Ltime)
+	logger := &logging.Logger{
+		Logger: l,
+	}
+
+	exited := make(chan int, 1)
+	exit = func(code int) { exited <- code }
+	defer func() { exit = os.Exit }()
+
+	tt := []struct {
+		name     string
+		method   string
+		path     string
+		status   int
+		response string
+		exitCode int
+		wait     time.Duration
+	}{
+		{
+			name:     "default exit",
+			method:   "GET",
+			path:     "/crash",
+			status:   http.StatusOK,
+			exitCode: 137,
+		},
+		{
+			name:     "exit with code",
+			method:   "GET",
+			path:     "/crash?mode=exit&code=3",
+			status:   http.StatusOK,
+			exitCode: 3,
+		},
+		{
+			name:     "exit after the response",
+			method:   "GET",
+			path:     "/crash?mode=after-response&code=1",
+			status:   http.StatusOK,
+			response: "Crashing",
+			exitCode: 1,
+		},
+		{
+			name:     "delayed exit",
+			method:   "GET",
+			path:     "/crash?mode=delayed&after=1",
+			status:   http.StatusAccepted,
+			response: "Crashing in 1s",
+			exitCode: 137,
+			wait:     2 * time.Second,
+		},
+		{
+			name:     "delayed without after",
+			method:   "GET",
+			path:     "/crash?mode=delayed",
+			status:   http.StatusBadRequest,
+			exitCode: -1,
+		},
+		{
+			name:     "exit code out of range",
+			method:   "GET",
+			path:     "/crash?mode=exit&code=256",
+			status:   http.StatusBadRequest,
+			exitCode: -1,
+		},
+		{
+			name:     "unknown mode",
+			method:   "GET",
+			path:     "/crash?mode=boom",
+			status:   http.StatusBadRequest,
+			exitCode: -1,
+		},
+		{
+			name:     "POST request",
+			method:   "POST",
+			path:     "/crash",
+			status:   http.StatusMethodNotAllowed,
+			exitCode: -1,
+		},
+	}
+
+	for _, tr := range tt {
+		handler := HandlerCrash(*logger, config.NewStore(helpers.ListEnvs))
+
+		t.Run(tr.name, func(t *testing.T) {
+			req := httptest.NewRequest(tr.method, tr.path, nil)
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tr.status, rr.Code)
+			if tr.response != "" {
+				assert.Equal(t, tr.response, rr.Body.String())
+			}
+			if tr.exitCode < 0 {
+				assert.Empty(t, exited)
+				return
+			}
+			select {
+			case code := <-exited:
+				assert.Equal(t, tr.exitCode, code)
+			case <-time.After(tr.wait + 100*time.Millisecond):
+				t.Error("the service didn't exit")
+			}
+		})
+	}
+}
+
+func TestCrashDeadlock(t *testing.T) {
+	l := log.New(os.Stdout,
+		"Test Logger: ",
+		log.Ldate|log.Ltime)
+	logger := &logging.Logger{
+		Logger: l,
+	}
+	handler := HandlerCrash(*logger, config.NewStore(helpers.ListEnvs))
+	defer freeze.Unlock()
+
+	// not wrapped by Freezable, so the deadlock request returns
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/crash?mode=deadlock", nil))
+
+	served := make(chan struct{})
+	frozen := Freezable(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		close(served)
+	}))
+	go frozen.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	select {
+	case <-served:
+		t.Error("request served while deadlocked")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
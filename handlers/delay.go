@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/efbar/minimal-service/helpers"
+)
+
+type ctxKey int
+
+const delayKey ctxKey = iota
+
+// DelayInfo ... delay applied before serving the request
+type DelayInfo struct {
+	Distribution string  `json:"distribution"`
+	Milliseconds float64 `json:"ms"`
+}
+
+// delayDistributions ... the distributions sampleDelay knows
+var delayDistributions = []string{"fixed", "uniform", "normal", "lognormal", "exponential", "percentiles"}
+
+// Delayer ... sleep before serving for a delay sampled from DELAY_DISTRIBUTION,
+// only for DELAY_PERCENT of the requests
+func (h *Data) Delayer(envs map[string]string, rng *rand.Rand) (*DelayInfo, error) {
+
+	percent := 100
+	if len(envs["DELAY_PERCENT"]) != 0 {
+		p, err := strconv.Atoi(envs["DELAY_PERCENT"])
+		if err != nil {
+			return nil, err
+		}
+		percent = p
+	}
+
+	distribution, ms, err := sampleDelay(envs, rng)
+	if err != nil || len(distribution) == 0 || !helpers.RandBool(rng, percent, &h.l) {
+		return nil, err
+	}
+
+	d := time.Duration(ms * float64(time.Millisecond))
+	h.l.Debug(envs["DEBUG"], "Sleeping", d.String(), "from", distribution, "distribution")
+	time.Sleep(d)
+	h.l.Debug(envs["DEBUG"], "Done")
+
+	return &DelayInfo{Distribution: distribution, Milliseconds: ms}, nil
+}
+
+// sampleDelay ... pick a delay in milliseconds from the configured distribution,
+// no distribution means a fixed DELAY_MS or up to DELAY_MAX seconds
+func sampleDelay(envs map[string]string, rng *rand.Rand) (string, float64, error) {
+	distribution := envs["DELAY_DISTRIBUTION"]
+
+	fixed, err := msEnv(envs, "DELAY_MS")
+	if err != nil {
+		return "", 0, err
+	}
+	if len(distribution) == 0 {
+		delayMax, err := msEnv(envs, "DELAY_MAX")
+		if err != nil {
+			return "", 0, err
+		}
+		if fixed > 0 {
+			return "fixed", fixed, nil
+		}
+		if delayMax > 0 {
+			return "uniform", rng.Float64() * delayMax * 1000, nil
+		}
+		return "", 0, nil
+	}
+
+	var params = map[string]float64{}
+	for _, key := range []string{"DELAY_MIN_MS", "DELAY_MAX_MS", "DELAY_MEAN_MS", "DELAY_STDDEV_MS", "DELAY_P50_MS", "DELAY_P90_MS", "DELAY_P99_MS"} {
+		if params[key], err = msEnv(envs, key); err != nil {
+			return "", 0, err
+		}
+	}
+	min, max := params["DELAY_MIN_MS"], params["DELAY_MAX_MS"]
+	mean, stddev := params["DELAY_MEAN_MS"], params["DELAY_STDDEV_MS"]
+
+	var ms float64
+	switch distribution {
+	case "fixed":
+		return distribution, fixed, nil
+	case "uniform":
+		if max < min {
+			return "", 0, fmt.Errorf("DELAY_MAX_MS %v lower than DELAY_MIN_MS %v", max, min)
+		}
+		return distribution, min + rng.Float64()*(max-min), nil
+	case "normal":
+		ms = mean + rng.NormFloat64()*stddev
+	case "lognormal":
+		if mean <= 0 {
+			return "", 0, fmt.Errorf("DELAY_MEAN_MS must be positive for lognormal distribution")
+		}
+		// mean and stddev are the ones of the delay, not of its logarithm
+		sigma := math.Sqrt(math.Log(1 + (stddev*stddev)/(mean*mean)))
+		mu := math.Log(mean) - sigma*sigma/2
+		ms = math.Exp(mu + sigma*rng.NormFloat64())
+	case "exponential":
+		ms = rng.ExpFloat64() * mean
+	case "percentiles":
+		ms, err = samplePercentiles(rng, min, max, params["DELAY_P50_MS"], params["DELAY_P90_MS"], params["DELAY_P99_MS"])
+		if err != nil {
+			return "", 0, err
+		}
+		return distribution, ms, nil
+	default:
+		return "", 0, fmt.Errorf("unknown delay distribution %s", distribution)
+	}
+
+	ms = math.Max(ms, min)
+	if max > 0 {
+		ms = math.Min(ms, max)
+	}
+
+	return distribution, ms, nil
+}
+
+// samplePercentiles ... sample a delay whose p50, p90 and p99 match the targets,
+// interpolating linearly between min, the targets and max
+func samplePercentiles(rng *rand.Rand, min, max, p50, p90, p99 float64) (float64, error) {
+	if max == 0 {
+		max = p99 + (p99 - p90)
+	}
+	points := []struct{ q, ms float64 }{{0, min}, {0.5, p50}, {0.9, p90}, {0.99, p99}, {1, max}}
+	for i := 1; i < len(points); i++ {
+		if points[i].ms < points[i-1].ms {
+			return 0, fmt.Errorf("delay percentiles must not decrease: min %v, p50 %v, p90 %v, p99 %v, max %v", min, p50, p90, p99, max)
+		}
+	}
+
+	u := rng.Float64()
+	for i := 1; i < len(points); i++ {
+		if u < points[i].q {
+			lo, hi := points[i-1], points[i]
+			return lo.ms + (u-lo.q)/(hi.q-lo.q)*(hi.ms-lo.ms), nil
+		}
+	}
+
+	return max, nil
+}
+
+// msEnv ... float value of a delay env, 0 if not set
+func msEnv(envs map[string]string, key string) (float64, error) {
+	if len(envs[key]) == 0 {
+		return 0, nil
+	}
+	ms, err := strconv.ParseFloat(envs[key], 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %s", key, err.Error())
+	}
+
+	return ms, nil
+}
+
+// withDelay ... attach the applied delay to the request
+func withDelay(r *http.Request, info *DelayInfo) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), delayKey, info))
+}
+
+// delayFrom ... delay applied to the request, nil if none
+func delayFrom(r *http.Request) *DelayInfo {
+	info, _ := r.Context().Value(delayKey).(*DelayInfo)
+	return info
+}
+
+// delayHeaders ... add the applied delay to the headers sent back and traced
+func delayHeaders(r *http.Request, m map[string]string) map[string]string {
+	if info := delayFrom(r); info != nil {
+		m["Delay-distribution"] = info.Distribution
+		m["Delay-ms"] = fmt.Sprint(info.Milliseconds)
+	}
+
+	return m
+}
+
+func validDistribution(value string) error {
+	if !contains(delayDistributions, value) {
+		return fmt.Errorf("unknown delay distribution %s", value)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/efbar/minimal-service/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampleDelay(t *testing.T) {
+	tt := []struct {
+		name         string
+		envs         map[string]string
+		distribution string
+		min          float64
+		max          float64
+		err          bool
+	}{
+		{
+			name: "no delay",
+			envs: map[string]string{"DELAY_MAX": "0"},
+		},
+		{
+			name:         "legacy DELAY_MAX in seconds",
+			envs:         map[string]string{"DELAY_MAX": "2"},
+			distribution: "uniform",
+			max:          2000,
+		},
+		{
+			name:         "legacy DELAY_MS",
+			envs:         map[string]string{"DELAY_MS": "150", "DELAY_MAX": "2"},
+			distribution: "fixed",
+			min:          150,
+			max:          150,
+		},
+		{
+			name:         "fixed",
+			envs:         map[string]string{"DELAY_DISTRIBUTION": "fixed", "DELAY_MS": "12.5"},
+			distribution: "fixed",
+			min:          12.5,
+			max:          12.5,
+		},
+		{
+			name:         "uniform",
+			envs:         map[string]string{"DELAY_DISTRIBUTION": "uniform", "DELAY_MIN_MS": "10", "DELAY_MAX_MS": "20"},
+			distribution: "uniform",
+			min:          10,
+			max:          20,
+		},
+		{
+			name: "uniform with max lower than min",
+			envs: map[string]string{"DELAY_DISTRIBUTION": "uniform", "DELAY_MIN_MS": "20", "DELAY_MAX_MS": "10"},
+			err:  true,
+		},
+		{
+			name:         "normal clamped",
+			envs:         map[string]string{"DELAY_DISTRIBUTION": "normal", "DELAY_MEAN_MS": "100", "DELAY_STDDEV_MS": "50", "DELAY_MIN_MS": "80", "DELAY_MAX_MS": "120"},
+			distribution: "normal",
+			min:          80,
+			max:          120,
+		},
+		{
+			name:         "lognormal",
+			envs:         map[string]string{"DELAY_DISTRIBUTION": "lognormal", "DELAY_MEAN_MS": "100", "DELAY_STDDEV_MS": "50", "DELAY_MAX_MS": "1000"},
+			distribution: "lognormal",
+			max:          1000,
+		},
+		{
+			name: "lognormal without mean",
+			envs: map[string]string{"DELAY_DISTRIBUTION": "lognormal"},
+			err:  true,
+		},
+		{
+			name:         "exponential",
+			envs:         map[string]string{"DELAY_DISTRIBUTION": "exponential", "DELAY_MEAN_MS": "100", "DELAY_MAX_MS": "500"},
+			distribution: "exponential",
+			max:          500,
+		},
+		{
+			name:         "percentiles",
+			envs:         map[string]string{"DELAY_DISTRIBUTION": "percentiles", "DELAY_P50_MS": "100", "DELAY_P90_MS": "200", "DELAY_P99_MS": "400"},
+			distribution: "percentiles",
+			max:          600,
+		},
+		{
+			name: "decreasing percentiles",
+			envs: map[string]string{"DELAY_DISTRIBUTION": "percentiles", "DELAY_P50_MS": "300", "DELAY_P90_MS": "200", "DELAY_P99_MS": "400"},
+			err:  true,
+		},
+		{
+			name: "unknown distribution",
+			envs: map[string]string{"DELAY_DISTRIBUTION": "pareto"},
+			err:  true,
+		},
+		{
+			name: "not numeric value",
+			envs: map[string]string{"DELAY_DISTRIBUTION": "exponential", "DELAY_MEAN_MS": "fast"},
+			err:  true,
+		},
+	}
+
+	for _, tr := range tt {
+		t.Run(tr.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				distribution, ms, err := sampleDelay(tr.envs, unseeded)
+				if tr.err {
+					assert.Error(t, err)
+					return
+				}
+				assert.NoError(t, err)
+				assert.Equal(t, tr.distribution, distribution)
+				assert.GreaterOrEqual(t, ms, tr.min)
+				assert.LessOrEqual(t, ms, tr.max)
+			}
+		})
+	}
+}
+
+func TestSamplePercentiles(t *testing.T) {
+	samples := make([]float64, 20000)
+	for i := range samples {
+		ms, err := samplePercentiles(unseeded, 0, 0, 100, 200, 400)
+		assert.NoError(t, err)
+		samples[i] = ms
+	}
+	sort.Float64s(samples)
+
+	assert.InDelta(t, 100, samples[len(samples)*50/100], 10)
+	assert.InDelta(t, 200, samples[len(samples)*90/100], 20)
+	assert.InDelta(t, 400, samples[len(samples)*99/100], 40)
+}
+
+func TestDelayInResponse(t *testing.T) {
+	handler := setupReqHTTPTest(t)
+	handler.envs = config.NewStore(map[string]string{
+		"DELAY_DISTRIBUTION": "uniform",
+		"DELAY_MIN_MS":       "20",
+		"DELAY_MAX_MS":       "30",
+		"TRACING":            "0",
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	rr := httptest.NewRecorder()
+
+	st := time.Now()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.GreaterOrEqual(t, time.Since(st), 20*time.Millisecond)
+
+	var tmpl = &JSONResponse{}
+	getJBody(rr.Body, tmpl)
+	if assert.NotNil(t, tmpl.Delay) {
+		assert.Equal(t, "uniform", tmpl.Delay.Distribution)
+		assert.GreaterOrEqual(t, tmpl.Delay.Milliseconds, 20.0)
+		assert.LessOrEqual(t, tmpl.Delay.Milliseconds, 30.0)
+	}
+	assert.Equal(t, "uniform", tmpl.Headers["Delay-distribution"])
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// faultHeaders ... request headers that override fault envs for a single request,
+// Envoy's x-envoy-fault-* names are accepted as aliases
+var faultHeaders = []struct {
+	env   string
+	names []string
+	valid func(string) error
+}{
+	{"DISCARD_QUOTA", []string{"X-Fault-Abort-Percent", "X-Envoy-Fault-Abort-Request-Percentage"}, validInt},
+	{"REJECT_STATUS", []string{"X-Fault-Status", "X-Envoy-Fault-Abort-Request"}, validStatusWeights},
+	{"DELAY_MS", []string{"X-Fault-Delay", "X-Envoy-Fault-Delay-Request"}, validInt},
+	{"DELAY_PERCENT", []string{"X-Fault-Delay-Percent", "X-Envoy-Fault-Delay-Request-Percentage"}, validInt},
+	{"DISCARD_MODE", []string{"X-Fault-Connection"}, validDiscardMode},
+	{"DRIP_RATE", []string{"X-Fault-Drip-Rate"}, validInt},
+}
+
+// faultEnvs ... snapshot of the envs with the first matching fault rule
+// and then the fault headers of the request layered on top
+func (h *Data) faultEnvs(r *http.Request) map[string]string {
+	envs := h.envs.Snapshot()
+
+	rules, err := parseRules(envs["FAULT_RULES"])
+	if err != nil {
+		h.l.Error("FAULT_RULES,", err.Error())
+	}
+	for _, rule := range rules {
+		if rule.matches(r) {
+			h.l.Debug(envs["DEBUG"], "Fault rule", rule.Name)
+			overlayFaults(envs, rule.Envs)
+			break
+		}
+	}
+
+	if envs["FAULT_HEADERS"] == "0" {
+		return envs
+	}
+
+	found := map[string]string{}
+	for _, fh := range faultHeaders {
+		for _, name := range fh.names {
+			value := r.Header.Get(name)
+			if len(value) == 0 {
+				continue
+			}
+			if err := fh.valid(value); err != nil {
+				h.l.Debug(envs["DEBUG"], "Ignoring fault header", name, value)
+				continue
+			}
+			h.l.Debug(envs["DEBUG"], "Fault header", name, value)
+			found[fh.env] = value
+			break
+		}
+	}
+	overlayFaults(envs, found)
+
+	return envs
+}
+
+// overlayFaults ... set faults on top of envs, with the envs they imply if not set too
+func overlayFaults(envs map[string]string, faults map[string]string) {
+	for key, value := range faults {
+		envs[key] = value
+	}
+	set := func(key string, value string) {
+		if _, ok := faults[key]; !ok {
+			envs[key] = value
+		}
+	}
+
+	// an abort status means the request is rejected,
+	// always unless a percentage is given too
+	if _, ok := faults["REJECT_STATUS"]; ok {
+		set("REJECT", "1")
+		set("DISCARD_QUOTA", "100")
+	}
+	// same for a connection fault, that is never a rejection
+	if _, ok := faults["DISCARD_MODE"]; ok {
+		set("REJECT", "0")
+		set("DISCARD_QUOTA", "100")
+	}
+
+	// a delay alone is a fixed one
+	if _, ok := faults["DELAY_MS"]; ok {
+		set("DELAY_DISTRIBUTION", "fixed")
+	}
+}
+
+// statusWeight ... a status code and its weight in a rejection distribution
+type statusWeight struct {
+	code   int
+	weight int
+}
+
+// parseStatusWeights ... parse a distribution like "503:60,429:30,500:10",
+// the weight can be omitted and it will be 1
+func parseStatusWeights(value string) ([]statusWeight, error) {
+	var weights []statusWeight
+	for _, elem := range strings.Split(value, ",") {
+		pair := strings.SplitN(strings.TrimSpace(elem), ":", 2)
+		code, err := strconv.Atoi(pair[0])
+		if err != nil {
+			return nil, err
+		}
+		if code < 200 || code > 599 {
+			return nil, fmt.Errorf("status code %d out of range", code)
+		}
+		weight := 1
+		if len(pair) == 2 {
+			weight, err = strconv.Atoi(pair[1])
+			if err != nil {
+				return nil, err
+			}
+			if weight < 0 {
+				return nil, fmt.Errorf("negative weight for status code %d", code)
+			}
+		}
+		weights = append(weights, statusWeight{code, weight})
+	}
+
+	return weights, nil
+}
+
+// rejectStatus ... pick the status code of a rejected request from the REJECT_STATUS distribution,
+// 500 if not valid
+func rejectStatus(value string, rng *rand.Rand) int {
+	weights, err := parseStatusWeights(value)
+	if err != nil {
+		return http.StatusInternalServerError
+	}
+
+	total := 0
+	for _, w := range weights {
+		total += w.weight
+	}
+	if total == 0 {
+		return http.StatusInternalServerError
+	}
+
+	n := rng.Intn(total)
+	for _, w := range weights {
+		if n < w.weight {
+			return w.code
+		}
+		n -= w.weight
+	}
+
+	return http.StatusInternalServerError
+}
+
+// retryAfter ... Retry-After value for the status code, REJECT_RETRY_AFTER can be
+// a single value for every code or a list like "503:5,429:10"
+func retryAfter(value string, code int) string {
+	for _, elem := range strings.Split(value, ",") {
+		pair := strings.SplitN(strings.TrimSpace(elem), ":", 2)
+		if len(pair) == 1 {
+			return pair[0]
+		}
+		if pair[0] == strconv.Itoa(code) {
+			return pair[1]
+		}
+	}
+
+	return ""
+}
+
+// rejectBody ... body sent with a rejected request, REJECT_BODY_<code> or the status text
+func rejectBody(envs map[string]string, code int) string {
+	if body := envs["REJECT_BODY_"+strconv.Itoa(code)]; len(body) != 0 {
+		return body
+	}
+
+	return http.StatusText(code)
+}
+
+func validInt(value string) error {
+	_, err := strconv.Atoi(value)
+	return err
+}
+
+// validRetryAfter ... seconds, or a list of status codes and seconds like "503:5,429:10"
+func validRetryAfter(value string) error {
+	elems := strings.Split(value, ",")
+	for _, elem := range elems {
+		pair := strings.SplitN(strings.TrimSpace(elem), ":", 2)
+		if len(pair) == 1 && len(elems) > 1 {
+			return fmt.Errorf("seconds without status code in %s", value)
+		}
+		if len(pair) == 2 {
+			code, err := strconv.Atoi(pair[0])
+			if err != nil {
+				return err
+			}
+			if code < 200 || code > 599 {
+				return fmt.Errorf("status code %d out of range", code)
+			}
+		}
+		seconds, err := strconv.Atoi(pair[len(pair)-1])
+		if err != nil {
+			return err
+		}
+		if seconds < 0 {
+			return fmt.Errorf("negative seconds in %s", value)
+		}
+	}
+
+	return nil
+}
+
+func validStatusWeights(value string) error {
+	_, err := parseStatusWeights(value)
+	return err
+}
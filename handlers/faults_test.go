@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/efbar/minimal-service/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFaultHeaders(t *testing.T) {
+	tt := []struct {
+		name        string
+		headers     map[string]string
+		contentType string
+		envs        map[string]string
+		status      int
+		response    string
+		respHeaders map[string]string
+		minDuration time.Duration
+	}{
+		{
+			name:     "abort status header",
+			headers:  map[string]string{"X-Fault-Status": "503"},
+			status:   http.StatusServiceUnavailable,
+			response: "Service Unavailable\n",
+		},
+		{
+			name:        "abort status header, JSON version",
+			headers:     map[string]string{"X-Fault-Status": "429"},
+			contentType: "application/json",
+			status:      http.StatusTooManyRequests,
+			response:    "\"Too Many Requests\"\n",
+		},
+		{
+			name:     "Envoy abort header",
+			headers:  map[string]string{"X-Envoy-Fault-Abort-Request": "504"},
+			status:   http.StatusGatewayTimeout,
+			response: "Gateway Timeout\n",
+		},
+		{
+			name: "abort status header with zero percentage",
+			headers: map[string]string{
+				"X-Fault-Status":        "503",
+				"X-Fault-Abort-Percent": "0",
+			},
+			status: http.StatusOK,
+		},
+		{
+			name:    "abort percentage header on top of REJECT env",
+			headers: map[string]string{"X-Fault-Abort-Percent": "100"},
+			envs: map[string]string{
+				"REJECT":        "1",
+				"REJECT_STATUS": "502",
+			},
+			status:   http.StatusBadGateway,
+			response: "Bad Gateway\n",
+		},
+		{
+			name:    "headers override env defaults",
+			headers: map[string]string{"X-Fault-Abort-Percent": "0"},
+			envs: map[string]string{
+				"DISCARD_QUOTA": "100",
+				"REJECT":        "1",
+			},
+			status: http.StatusOK,
+		},
+		{
+			name:    "not numeric header is ignored",
+			headers: map[string]string{"X-Fault-Status": "boom"},
+			status:  http.StatusOK,
+		},
+		{
+			name:    "fault headers disabled",
+			headers: map[string]string{"X-Fault-Status": "503"},
+			envs: map[string]string{
+				"FAULT_HEADERS": "0",
+			},
+			status: http.StatusOK,
+		},
+		{
+			name:    "weighted status distribution with body and Retry-After",
+			headers: map[string]string{"X-Fault-Status": "503:100,429:0"},
+			envs: map[string]string{
+				"REJECT_RETRY_AFTER": "429:30,503:7",
+				"REJECT_BODY_503":    "come back later",
+			},
+			status:      http.StatusServiceUnavailable,
+			response:    "come back later\n",
+			respHeaders: map[string]string{"Retry-After": "7"},
+		},
+		{
+			name: "per code body, JSON version",
+			envs: map[string]string{
+				"DISCARD_QUOTA":      "100",
+				"REJECT":             "1",
+				"REJECT_STATUS":      "429",
+				"REJECT_RETRY_AFTER": "10",
+				"REJECT_BODY_429":    "slow down",
+			},
+			contentType: "application/json",
+			status:      http.StatusTooManyRequests,
+			response:    "\"slow down\"\n",
+			respHeaders: map[string]string{"Retry-After": "10"},
+		},
+		{
+			name:        "delay header",
+			headers:     map[string]string{"X-Fault-Delay": "50"},
+			status:      http.StatusOK,
+			minDuration: 50 * time.Millisecond,
+		},
+		{
+			name: "Envoy delay header",
+			headers: map[string]string{
+				"X-Envoy-Fault-Delay-Request":            "50",
+				"X-Envoy-Fault-Delay-Request-Percentage": "100",
+			},
+			status:      http.StatusOK,
+			minDuration: 50 * time.Millisecond,
+		},
+	}
+
+	for _, tr := range tt {
+		handler := setupReqHTTPTest(t)
+		envs := map[string]string{
+			"DELAY_MAX": "0",
+			"TRACING":   "0",
+		}
+		for key, value := range tr.envs {
+			envs[key] = value
+		}
+		handler.envs = config.NewStore(envs)
+
+		t.Run(tr.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			for key, value := range tr.headers {
+				req.Header.Set(key, value)
+			}
+			if tr.contentType != "" {
+				req.Header.Set("Content-type", tr.contentType)
+			}
+			rr := httptest.NewRecorder()
+
+			st := time.Now()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tr.status, rr.Result().StatusCode)
+			assert.GreaterOrEqual(t, time.Since(st), tr.minDuration)
+			if tr.response != "" {
+				assert.Equal(t, tr.response, rr.Body.String())
+			}
+			for key, value := range tr.respHeaders {
+				assert.Equal(t, value, rr.Header().Get(key))
+			}
+		})
+	}
+}
+
+func TestRejectStatus(t *testing.T) {
+	tt := []struct {
+		name  string
+		value string
+		codes []int
+	}{
+		{
+			name:  "single code",
+			value: "503",
+			codes: []int{503},
+		},
+		{
+			name:  "weighted codes",
+			value: "503:60,429:30,500:10",
+			codes: []int{503, 429, 500},
+		},
+		{
+			name:  "zero weight is never picked",
+			value: "502:0,504:1",
+			codes: []int{504},
+		},
+		{
+			name:  "not valid distribution",
+			value: "503:x",
+			codes: []int{500},
+		},
+		{
+			name:  "code out of range",
+			value: "99",
+			codes: []int{500},
+		},
+	}
+
+	for _, tr := range tt {
+		t.Run(tr.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				assert.Contains(t, tr.codes, rejectStatus(tr.value, unseeded))
+			}
+		})
+	}
+}
+
+func TestValidRetryAfter(t *testing.T) {
+	for _, value := range []string{"5", "0", "503:5", "503:5,429:10", " 503:5, 429:10"} {
+		assert.NoError(t, validRetryAfter(value), value)
+	}
+	for _, value := range []string{"soon", "-1", "503:soon", "abc:5", "99:5", "503:-5", "503:5,10", "503:5,"} {
+		assert.Error(t, validRetryAfter(value), value)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	assert.Equal(t, "5", retryAfter("5", 503))
+	assert.Equal(t, "10", retryAfter("503:5,429:10", 429))
+	assert.Equal(t, "", retryAfter("503:5", 500))
+	assert.Equal(t, "", retryAfter("", 500))
+}
@@ -3,34 +3,74 @@ package handlers
 import (
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/efbar/minimal-service/config"
+	"github.com/efbar/minimal-service/helpers"
 	"github.com/efbar/minimal-service/logging"
 )
 
 // Health ...
 type Health struct {
-	log  logging.Logger
-	envs map[string]string
+	log   logging.Logger
+	envs  *config.Store
+	start time.Time
 }
 
 // HandlerHealth ...
-func HandlerHealth(l logging.Logger, envs map[string]string) *Health {
+func HandlerHealth(l logging.Logger, envs *config.Store) *Health {
 	return &Health{
-		log:  l,
-		envs: envs,
+		log:   l,
+		envs:  envs,
+		start: time.Now(),
 	}
 }
 
 // ServeHTTP ...
 func (h *Health) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
-	h.log.Debug(h.envs["DEBUG"], r.Method, "on", r.URL.String(), "from", r.RemoteAddr)
+	h.log.Debug(h.envs.Get("DEBUG"), r.Method, "on", r.URL.String(), "from", r.RemoteAddr)
 
 	if r.Method == http.MethodGet {
+		if delay, _ := strconv.Atoi(h.envs.Get("HEALTH_DELAY_MS")); delay > 0 {
+			time.Sleep(time.Duration(delay) * time.Millisecond)
+		}
+		if wantsReport(r) {
+			h.encodeReport(rw)
+			return
+		}
+		if failing, reason := h.failing(); failing {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(rw, "Status KO")
+			h.log.Debug(h.envs.Get("DEBUG"), "Status KO,", reason)
+			return
+		}
 		rw.WriteHeader(http.StatusOK)
 		fmt.Fprint(rw, "Status OK")
-		h.log.Debug(h.envs["DEBUG"], "Status OK")
+		h.log.Debug(h.envs.Get("DEBUG"), "Status OK")
 	} else {
 		rw.WriteHeader(http.StatusMethodNotAllowed)
 	}
 
 }
+
+// failing ... true if the health check has to fail, and why
+func (h *Health) failing() (bool, string) {
+	uptime := time.Since(h.start)
+
+	if h.envs.Get("HEALTH_FAIL") == "1" {
+		return true, "HEALTH_FAIL"
+	}
+	if after, _ := strconv.Atoi(h.envs.Get("HEALTH_FAIL_AFTER")); after > 0 && uptime >= time.Duration(after)*time.Second {
+		return true, "failing after " + strconv.Itoa(after) + "s of uptime"
+	}
+	// healthy for an interval, failing for the next one and so on
+	if interval, _ := strconv.Atoi(h.envs.Get("HEALTH_FLAP_INTERVAL")); interval > 0 && (uptime/(time.Duration(interval)*time.Second))%2 == 1 {
+		return true, "flapping every " + strconv.Itoa(interval) + "s"
+	}
+	if percent, _ := strconv.Atoi(h.envs.Get("HEALTH_FAIL_PERCENT")); helpers.RandBool(unseeded, percent, &h.log) {
+		return true, "failing " + strconv.Itoa(percent) + "% of checks"
+	}
+
+	return false, ""
+}
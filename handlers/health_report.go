@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/efbar/minimal-service/helpers"
+	"github.com/efbar/minimal-service/tracer"
+)
+
+// certFile ... certificate served when HTTPS is enabled
+var certFile = "certs/minimalservice.crt"
+
+// health statuses, from the best to the worst
+const (
+	healthPass = "pass"
+	healthWarn = "warn"
+	healthFail = "fail"
+)
+
+// HealthReport ... health report in the format of the IETF health check draft
+type HealthReport struct {
+	Status      string                   `json:"status"`
+	ServiceID   string                   `json:"serviceId"`
+	Description string                   `json:"description"`
+	Output      string                   `json:"output,omitempty"`
+	Checks      map[string][]HealthCheck `json:"checks"`
+}
+
+// HealthCheck ... the status of a single component
+type HealthCheck struct {
+	ComponentID   string      `json:"componentId,omitempty"`
+	ComponentType string      `json:"componentType"`
+	ObservedValue interface{} `json:"observedValue,omitempty"`
+	ObservedUnit  string      `json:"observedUnit,omitempty"`
+	Status        string      `json:"status"`
+	Time          string      `json:"time"`
+	Output        string      `json:"output,omitempty"`
+}
+
+// wantsReport ... true if the client asked for the JSON health report
+func wantsReport(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/health+json") || strings.Contains(accept, "application/json")
+}
+
+// report ... run the component checks and roll them up in the overall status
+func (h *Health) report() *HealthReport {
+	envs := h.envs.Snapshot()
+	now := time.Now().UTC().Format(time.RFC3339)
+	hostname, _ := helpers.GetHostname()
+
+	report := &HealthReport{
+		Status:      healthPass,
+		ServiceID:   hostname,
+		Description: "minimal-service",
+		Checks:      map[string][]HealthCheck{},
+	}
+	add := func(name string, check HealthCheck) {
+		check.Time = now
+		report.Checks[name] = append(report.Checks[name], check)
+		report.Status = worstStatus(report.Status, check.Status)
+	}
+
+	add("uptime", HealthCheck{
+		ComponentType: "system",
+		ObservedValue: int64(time.Since(h.start).Seconds()),
+		ObservedUnit:  "s",
+		Status:        healthPass,
+	})
+
+	if envs["CONNECT"] == "1" {
+		agent := envs["CONSUL_AGENT"]
+		if !strings.Contains(agent, "://") {
+			agent = "http://" + agent
+		}
+		add("consul:connectivity", dialCheck(agent, "component", dialEndpoint(agent, h.log, envs["DEBUG"])))
+	}
+
+	if envs["TRACING"] == "1" {
+		add("jaeger:connectivity", dialCheck(envs["JAEGER_URL"], "component", tracer.Reachable(envs["JAEGER_URL"])))
+	}
+
+	if envs["HTTPS"] == "true" {
+		warnDays, err := strconv.Atoi(envs["CERT_WARN_DAYS"])
+		if err != nil {
+			warnDays = 30
+		}
+		add("certificate:expiry", certCheck(certFile, warnDays))
+	}
+
+	for _, target := range bounceTargets(envs["BOUNCE_TARGETS"]) {
+		add("bounce:connectivity", dialCheck(target, "http", dialEndpoint(target, h.log, envs["DEBUG"])))
+	}
+
+	if failing, reason := h.failing(); failing {
+		report.Status = healthFail
+		report.Output = reason
+	}
+
+	return report
+}
+
+// encodeReport ... send the health report with the status code matching its status
+func (h *Health) encodeReport(rw http.ResponseWriter) {
+	report := h.report()
+
+	rw.Header().Set("Content-Type", "application/health+json")
+	if report.Status == healthFail {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if err := json.NewEncoder(rw).Encode(report); err != nil {
+		h.log.Error("error encoding json", err.Error())
+	}
+	h.log.Debug(h.envs.Get("DEBUG"), "Health report", report.Status)
+}
+
+// dialCheck ... result of a connectivity check
+func dialCheck(endpoint string, componentType string, err error) HealthCheck {
+	check := HealthCheck{
+		ComponentID:   endpoint,
+		ComponentType: componentType,
+		Status:        healthPass,
+	}
+	if err != nil {
+		check.Status = healthFail
+		check.Output = err.Error()
+	}
+
+	return check
+}
+
+// certCheck ... days before the certificate expires, warn if less than warnDays
+func certCheck(file string, warnDays int) HealthCheck {
+	check := HealthCheck{
+		ComponentID:   file,
+		ComponentType: "system",
+		ObservedUnit:  "days",
+		Status:        healthFail,
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		check.Output = err.Error()
+		return check
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		check.Output = "no PEM data found"
+		return check
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		check.Output = err.Error()
+		return check
+	}
+
+	days := int(time.Until(cert.NotAfter).Hours() / 24)
+	check.ObservedValue = days
+	switch {
+	case time.Now().After(cert.NotAfter):
+		check.Output = fmt.Sprintf("expired on %s", cert.NotAfter.UTC().Format(time.RFC3339))
+	case days < warnDays:
+		check.Status = healthWarn
+		check.Output = fmt.Sprintf("expiring on %s", cert.NotAfter.UTC().Format(time.RFC3339))
+	default:
+		check.Status = healthPass
+	}
+
+	return check
+}
+
+// worstStatus ... the worst of two health statuses
+func worstStatus(a string, b string) string {
+	rank := map[string]int{healthPass: 0, healthWarn: 1, healthFail: 2}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/efbar/minimal-service/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestCert ... write a self signed certificate expiring after validity
+func writeTestCert(t *testing.T, validity time.Duration) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "minimal-service"},
+		NotBefore:    time.Now().Add(-48 * time.Hour),
+		NotAfter:     time.Now().Add(validity),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(t.TempDir(), "test.crt")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func TestHealthReport(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	up := "http://" + listener.Addr().String()
+
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	down := "http://" + closed.Addr().String()
+	closed.Close()
+
+	tt := []struct {
+		name     string
+		accept   string
+		envs     map[string]string
+		validity time.Duration
+		status   int
+		report   string
+		checks   map[string]string
+	}{
+		{
+			name:   "only uptime",
+			accept: "application/health+json",
+			status: http.StatusOK,
+			report: healthPass,
+			checks: map[string]string{"uptime": healthPass},
+		},
+		{
+			name:   "plain JSON accepted",
+			accept: "application/json",
+			status: http.StatusOK,
+			report: healthPass,
+			checks: map[string]string{"uptime": healthPass},
+		},
+		{
+			name:   "failing health",
+			accept: "application/health+json",
+			envs:   map[string]string{"HEALTH_FAIL": "1"},
+			status: http.StatusServiceUnavailable,
+			report: healthFail,
+		},
+		{
+			name:   "reachable components",
+			accept: "application/health+json",
+			envs: map[string]string{
+				"CONNECT":        "1",
+				"CONSUL_AGENT":   listener.Addr().String(),
+				"TRACING":        "1",
+				"JAEGER_URL":     up + "/api/traces",
+				"BOUNCE_TARGETS": up,
+			},
+			status: http.StatusOK,
+			report: healthPass,
+			checks: map[string]string{
+				"consul:connectivity": healthPass,
+				"jaeger:connectivity": healthPass,
+				"bounce:connectivity": healthPass,
+			},
+		},
+		{
+			name:   "unreachable bounce target",
+			accept: "application/health+json",
+			envs:   map[string]string{"BOUNCE_TARGETS": down},
+			status: http.StatusServiceUnavailable,
+			report: healthFail,
+			checks: map[string]string{"bounce:connectivity": healthFail},
+		},
+		{
+			name:     "certificate expiring soon",
+			accept:   "application/health+json",
+			envs:     map[string]string{"HTTPS": "true", "CERT_WARN_DAYS": "30"},
+			validity: 10 * 24 * time.Hour,
+			status:   http.StatusOK,
+			report:   healthWarn,
+			checks:   map[string]string{"certificate:expiry": healthWarn},
+		},
+		{
+			name:     "certificate valid",
+			accept:   "application/health+json",
+			envs:     map[string]string{"HTTPS": "true", "CERT_WARN_DAYS": "30"},
+			validity: 90 * 24 * time.Hour,
+			status:   http.StatusOK,
+			report:   healthPass,
+			checks:   map[string]string{"certificate:expiry": healthPass},
+		},
+		{
+			name:     "certificate expired",
+			accept:   "application/health+json",
+			envs:     map[string]string{"HTTPS": "true"},
+			validity: -24 * time.Hour,
+			status:   http.StatusServiceUnavailable,
+			report:   healthFail,
+			checks:   map[string]string{"certificate:expiry": healthFail},
+		},
+	}
+
+	defaultCert := certFile
+	defer func() { certFile = defaultCert }()
+
+	for _, tr := range tt {
+		handler := setupHealthTest(t)
+		handler.envs = config.NewStore(tr.envs)
+		if tr.validity != 0 {
+			certFile = writeTestCert(t, tr.validity)
+		}
+
+		t.Run(tr.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/health", nil)
+			req.Header.Set("Accept", tr.accept)
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tr.status, rr.Code)
+			assert.Equal(t, "application/health+json", rr.Header().Get("Content-Type"))
+
+			report := HealthReport{}
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+			assert.Equal(t, tr.report, report.Status)
+			for name, status := range tr.checks {
+				if assert.NotEmpty(t, report.Checks[name], name) {
+					assert.Equal(t, status, report.Checks[name][0].Status, name)
+				}
+			}
+		})
+	}
+}
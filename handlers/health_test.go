@@ -6,7 +6,9 @@ import (
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/efbar/minimal-service/config"
 	"github.com/efbar/minimal-service/helpers"
 	"github.com/efbar/minimal-service/logging"
 	"github.com/stretchr/testify/assert"
@@ -19,10 +21,7 @@ func setupHealthTest(t *testing.T) *Health {
 	logger := &logging.Logger{
 		Logger: l,
 	}
-	return &Health{
-		*logger,
-		helpers.ListEnvs,
-	}
+	return HandlerHealth(*logger, config.NewStore(helpers.ListEnvs))
 }
 
 func TestHealthResp(t *testing.T) {
@@ -33,6 +32,9 @@ func TestHealthResp(t *testing.T) {
 		path     string
 		response string
 		status   int
+		envs     map[string]string
+		uptime   time.Duration
+		minTime  time.Duration
 	}{
 		{
 			name:     "GET request",
@@ -41,6 +43,67 @@ func TestHealthResp(t *testing.T) {
 			response: "Status OK",
 			status:   http.StatusOK,
 		},
+		{
+			name:     "GET request, failing health",
+			method:   "GET",
+			path:     "/health",
+			response: "Status KO",
+			status:   http.StatusServiceUnavailable,
+			envs:     map[string]string{"HEALTH_FAIL": "1"},
+		},
+		{
+			name:     "GET request, failing after uptime",
+			method:   "GET",
+			path:     "/health",
+			response: "Status KO",
+			status:   http.StatusServiceUnavailable,
+			envs:     map[string]string{"HEALTH_FAIL_AFTER": "30"},
+			uptime:   31 * time.Second,
+		},
+		{
+			name:     "GET request, not failing yet",
+			method:   "GET",
+			path:     "/health",
+			response: "Status OK",
+			status:   http.StatusOK,
+			envs:     map[string]string{"HEALTH_FAIL_AFTER": "30"},
+			uptime:   29 * time.Second,
+		},
+		{
+			name:     "GET request, flapping in failing interval",
+			method:   "GET",
+			path:     "/health",
+			response: "Status KO",
+			status:   http.StatusServiceUnavailable,
+			envs:     map[string]string{"HEALTH_FLAP_INTERVAL": "10"},
+			uptime:   15 * time.Second,
+		},
+		{
+			name:     "GET request, flapping in healthy interval",
+			method:   "GET",
+			path:     "/health",
+			response: "Status OK",
+			status:   http.StatusOK,
+			envs:     map[string]string{"HEALTH_FLAP_INTERVAL": "10"},
+			uptime:   25 * time.Second,
+		},
+		{
+			name:     "GET request, failing percentage",
+			method:   "GET",
+			path:     "/health",
+			response: "Status KO",
+			status:   http.StatusServiceUnavailable,
+			envs:     map[string]string{"HEALTH_FAIL_PERCENT": "100"},
+		},
+		{
+			name:     "GET request, slow health",
+			method:   "GET",
+			path:     "/health",
+			response: "Status OK",
+			status:   http.StatusOK,
+			envs:     map[string]string{"HEALTH_DELAY_MS": "50"},
+			minTime:  50 * time.Millisecond,
+		},
 		{
 			name:   "POST request",
 			method: "POST",
@@ -54,10 +117,16 @@ func TestHealthResp(t *testing.T) {
 
 		rr := httptest.NewRecorder()
 		handler := setupHealthTest(t)
+		if len(tr.envs) != 0 {
+			handler.envs = config.NewStore(tr.envs)
+		}
+		handler.start = time.Now().Add(-tr.uptime)
 
+		st := time.Now()
 		handler.ServeHTTP(rr, req)
 
-		assert.Equal(t, tr.status, rr.Code)
+		assert.Equal(t, tr.status, rr.Code, tr.name)
+		assert.GreaterOrEqual(t, time.Since(st), tr.minTime)
 		if tr.method == "GET" && tr.path == "/health" {
 			assert.Equal(t, tr.response, rr.Body.String())
 		}
@@ -3,10 +3,7 @@ package handlers
 import (
 	"encoding/json"
 	"io"
-	"math/rand"
 	"net/http"
-	"strconv"
-	"time"
 )
 
 // EncodeJSON ...
@@ -39,25 +36,6 @@ func CollectHeaders(r *http.Request, m map[string]string) map[string]string {
 	return headers
 }
 
-// Delayer ...
-func (h *Data) Delayer(delayEnv string) error {
-
-	h.l.Debug(h.envs["DEBUG"], "Delay == %s", delayEnv)
-	delay, err := strconv.Atoi(delayEnv)
-	if err != nil {
-		h.l.Error(err.Error())
-	}
-
-	rand.Seed(time.Now().UnixNano())
-	n := rand.Intn(delay)
-
-	h.l.Debug(h.envs["DEBUG"], "Delay == %d, so sleeping %d seconds...\n", strconv.Itoa(n), strconv.Itoa(n))
-	time.Sleep(time.Duration(n) * time.Second)
-	h.l.Debug(h.envs["DEBUG"], "Done")
-
-	return err
-}
-
 // ErrorJSON ...
 func ErrorJSON(rw http.ResponseWriter, err interface{}, code int) {
 	rw.Header().Set("Content-Type", "application/json")
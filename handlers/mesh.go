@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	agentconnect "github.com/hashicorp/consul/agent/connect"
+	consul "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/connect"
+)
+
+// errNoMesh ... consul:// endpoints are called only through Consul Connect
+var errNoMesh = errors.New("consul endpoints need CONNECT set to 1")
+
+// ConnectDialer ... dial an instance of a Consul Connect service with mTLS,
+// *connect.Service is the one used
+type ConnectDialer interface {
+	Dial(ctx context.Context, resolver connect.Resolver) (net.Conn, error)
+}
+
+// meshTarget ... a consul:// endpoint, the service with the datacenter and tag filters
+type meshTarget struct {
+	service    string
+	datacenter string
+	tag        string
+}
+
+// Mesh ... call consul://service?dc=&tag= endpoints through Consul Connect,
+// the instances are the healthy ones in the catalog and the intentions
+// decide if the call is allowed
+type Mesh struct {
+	dialer     ConnectDialer
+	client     *consul.Client
+	mu         sync.Mutex
+	transports map[meshTarget]*http.Transport
+}
+
+// NewMesh ...
+func NewMesh(dialer ConnectDialer, client *consul.Client) *Mesh {
+	return &Mesh{
+		dialer:     dialer,
+		client:     client,
+		transports: map[meshTarget]*http.Transport{},
+	}
+}
+
+// isMeshEndpoint ... true for consul:// endpoints
+func isMeshEndpoint(endpoint string) bool {
+	return strings.HasPrefix(strings.ToLower(endpoint), "consul://")
+}
+
+// RoundTrip ... send req to an instance of its consul:// target, dc and tag
+// are filters of the target and they are not sent
+func (m *Mesh) RoundTrip(req *http.Request) (*http.Response, error) {
+	query := req.URL.Query()
+	target := meshTarget{
+		service:    req.URL.Hostname(),
+		datacenter: query.Get("dc"),
+		tag:        query.Get("tag"),
+	}
+	if len(target.service) == 0 {
+		return nil, fmt.Errorf("consul endpoint without service")
+	}
+
+	out := req.Clone(req.Context())
+	out.URL.Scheme = "https"
+	if query.Has("dc") || query.Has("tag") {
+		query.Del("dc")
+		query.Del("tag")
+		out.URL.RawQuery = query.Encode()
+	}
+
+	return m.transport(target).RoundTrip(out)
+}
+
+// transport ... the transport of target, its connections are dialed
+// with mTLS to the instances resolved for target only
+func (m *Mesh) transport(target meshTarget) *http.Transport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t, ok := m.transports[target]; ok {
+		return t
+	}
+	resolver := &meshResolver{client: m.client, target: target}
+	// Connect offers h2 with ALPN, the transport has to speak it when it's agreed on
+	t := &http.Transport{
+		DialTLSContext: func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+			return m.dialer.Dial(ctx, resolver)
+		},
+		ForceAttemptHTTP2: true,
+	}
+	m.transports[target] = t
+
+	return t
+}
+
+// meshResolver ... resolve a target to one of its healthy Connect instances
+type meshResolver struct {
+	client *consul.Client
+	target meshTarget
+}
+
+// Resolve ... address of a random healthy instance and the identity
+// its certificate must have
+func (mr *meshResolver) Resolve(ctx context.Context) (string, agentconnect.CertURI, error) {
+	opts := &consul.QueryOptions{AllowStale: true, Datacenter: mr.target.datacenter}
+	entries, _, err := mr.client.Health().Connect(mr.target.service, mr.target.tag, true, opts.WithContext(ctx))
+	if err != nil {
+		return "", nil, err
+	}
+	if len(entries) == 0 {
+		return "", nil, fmt.Errorf("no healthy instances of %s", mr.target.service)
+	}
+	entry := entries[rand.Intn(len(entries))]
+
+	addr := entry.Service.Address
+	if len(addr) == 0 {
+		addr = entry.Node.Address
+	}
+	// a sidecar proxy stands for its destination service
+	var service string
+	if entry.Service.Proxy != nil {
+		service = entry.Service.Proxy.DestinationServiceName
+	}
+	if entry.Service.Connect != nil && entry.Service.Connect.Native {
+		service = entry.Service.Service
+	}
+	if len(service) == 0 {
+		return "", nil, fmt.Errorf("%s is not a Connect service", mr.target.service)
+	}
+
+	return net.JoinHostPort(addr, strconv.Itoa(entry.Service.Port)), &agentconnect.SpiffeIDService{
+		Namespace:  "default",
+		Datacenter: entry.Node.Datacenter,
+		Service:    service,
+	}, nil
+}
+
+// callerID ... SPIFFE ID of the client certificate, the identity of the
+// Connect service calling, empty if none
+func callerID(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+	for _, uri := range r.TLS.PeerCertificates[0].URIs {
+		if uri.Scheme == "spiffe" {
+			return uri.String()
+		}
+	}
+
+	return ""
+}
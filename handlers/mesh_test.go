@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/connect"
+	"github.com/stretchr/testify/assert"
+)
+
+// plainDialer ... dial the resolved instances without TLS, keeping the identities they should have
+type plainDialer struct {
+	mu         sync.Mutex
+	identities []string
+}
+
+func (d *plainDialer) Dial(ctx context.Context, resolver connect.Resolver) (net.Conn, error) {
+	addr, certURI, err := resolver.Resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	d.identities = append(d.identities, certURI.URI().String())
+	d.mu.Unlock()
+
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", addr)
+}
+
+// tlsDialer ... dial the resolved instances with TLS offering h2 only, like Connect does
+type tlsDialer struct {
+	roots *x509.CertPool
+}
+
+func (d *tlsDialer) Dial(ctx context.Context, resolver connect.Resolver) (net.Conn, error) {
+	addr, _, err := resolver.Resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &tls.Dialer{Config: &tls.Config{
+		RootCAs:    d.roots,
+		ServerName: "example.com",
+		NextProtos: []string{"h2"},
+	}}
+	return dialer.DialContext(ctx, "tcp", addr)
+}
+
+// consulAgent ... a Consul agent answering with instances for the Connect services in catalog
+func consulAgent(t *testing.T, catalog map[string]string) (*consul.Client, *url.Values) {
+	queries := &url.Values{}
+	agent := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		*queries = r.URL.Query()
+		entries := []*consul.ServiceEntry{}
+		name := r.URL.Path[len("/v1/health/connect/"):]
+		if addr, ok := catalog[name]; ok {
+			host, port, _ := net.SplitHostPort(addr)
+			p, _ := strconv.Atoi(port)
+			entries = append(entries, &consul.ServiceEntry{
+				Node: &consul.Node{Datacenter: "dc1", Address: host},
+				Service: &consul.AgentService{
+					Service: name,
+					Port:    p,
+					Connect: &consul.AgentServiceConnect{Native: true},
+				},
+			})
+		}
+		json.NewEncoder(rw).Encode(entries)
+	}))
+	t.Cleanup(agent.Close)
+
+	client, err := consul.NewClient(&consul.Config{Address: agent.Listener.Addr().String()})
+	assert.NoError(t, err)
+
+	return client, queries
+}
+
+func TestBounceMesh(t *testing.T) {
+	var received *http.Request
+	upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		received = r
+	}))
+	defer upstream.Close()
+
+	client, queries := consulAgent(t, map[string]string{"web": upstream.Listener.Addr().String()})
+
+	tt := []struct {
+		name     string
+		endpoint string
+		noMesh   bool
+		status   int
+		rawQuery string
+		host     string
+		dc       string
+		tag      string
+		identity string
+	}{
+		{
+			name:     "service in the local datacenter",
+			endpoint: "consul://web/path",
+			status:   http.StatusOK,
+			host:     "web",
+			identity: "spiffe:///ns/default/dc/dc1/svc/web",
+		},
+		{
+			name:     "datacenter and tag filters are not sent",
+			endpoint: "consul://web/path?dc=dc2&tag=v1&a=1",
+			status:   http.StatusOK,
+			rawQuery: "a=1",
+			host:     "web",
+			dc:       "dc2",
+			tag:      "v1",
+			identity: "spiffe:///ns/default/dc/dc1/svc/web",
+		},
+		{
+			name:     "no healthy instances",
+			endpoint: "consul://api",
+			status:   http.StatusBadGateway,
+		},
+		{
+			name:     "without Consul Connect",
+			endpoint: "consul://web",
+			noMesh:   true,
+			status:   http.StatusBadGateway,
+		},
+	}
+
+	for _, tr := range tt {
+		handler := setupReqHTTPTest(t)
+		dialer := &plainDialer{}
+		if !tr.noMesh {
+			handler.mesh = NewMesh(dialer, client)
+		}
+
+		t.Run(tr.name, func(t *testing.T) {
+			received = nil
+			body, _ := json.Marshal(&JSONPost{Rebound: "true", Endpoint: tr.endpoint})
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, httptest.NewRequest("POST", "/bounce", bytes.NewReader(body)))
+
+			assert.Equal(t, tr.status, rr.Code)
+			if tr.status != http.StatusOK {
+				assert.Nil(t, received)
+				return
+			}
+			if assert.NotNil(t, received) {
+				assert.Equal(t, "/path", received.URL.Path)
+				assert.Equal(t, tr.rawQuery, received.URL.RawQuery)
+				assert.Equal(t, tr.host, received.Host)
+			}
+			assert.Equal(t, tr.dc, queries.Get("dc"))
+			assert.Equal(t, tr.tag, queries.Get("tag"))
+			assert.Equal(t, []string{tr.identity}, dialer.identities)
+		})
+	}
+}
+
+func TestBounceMeshTLS(t *testing.T) {
+	protos := make(chan int, 2)
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		protos <- r.ProtoMajor
+	}))
+	upstream.EnableHTTP2 = true
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	client, _ := consulAgent(t, map[string]string{"web": upstream.Listener.Addr().String()})
+	roots := x509.NewCertPool()
+	roots.AddCert(upstream.Certificate())
+	handler := setupReqHTTPTest(t)
+	handler.mesh = NewMesh(&tlsDialer{roots: roots}, client)
+
+	for i := 0; i < 2; i++ {
+		body, _ := json.Marshal(&JSONPost{Rebound: "true", Endpoint: "consul://web/path"})
+		rr := httptest.NewRecorder()
+
+		handler.ServeHTTP(rr, httptest.NewRequest("POST", "/bounce", bytes.NewReader(body)))
+
+		if !assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+			return
+		}
+		assert.Equal(t, 2, <-protos)
+	}
+}
+
+func TestCallerID(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://11111111-2222-3333-4444-555555555555.consul/ns/default/dc/dc1/svc/web")
+	other, _ := url.Parse("https://web.example.com")
+
+	tt := []struct {
+		name   string
+		tls    *tls.ConnectionState
+		caller string
+	}{
+		{
+			name: "plain http",
+		},
+		{
+			name: "Connect client certificate",
+			tls: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{
+				{URIs: []*url.URL{other, spiffe}},
+			}},
+			caller: spiffe.String(),
+		},
+		{
+			name: "client certificate without SPIFFE ID",
+			tls: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{
+				{URIs: []*url.URL{other}},
+			}},
+		},
+		{
+			name: "no client certificate",
+			tls:  &tls.ConnectionState{},
+		},
+	}
+
+	for _, tr := range tt {
+		handler := setupReqHTTPTest(t)
+
+		t.Run(tr.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Content-type", "application/json")
+			req.TLS = tr.tls
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			js := &JSONResponse{}
+			assert.NoError(t, json.NewDecoder(rr.Body).Decode(js))
+			assert.Equal(t, tr.caller, js.Caller)
+		})
+	}
+}
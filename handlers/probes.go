@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/efbar/minimal-service/config"
+	"github.com/efbar/minimal-service/logging"
+)
+
+// Lifecycle ... state of the process read by the probes, updated by main
+type Lifecycle struct {
+	start      time.Time
+	registered int32
+	draining   int32
+	inFlight   int64
+}
+
+// NewLifecycle ...
+func NewLifecycle() *Lifecycle {
+	return &Lifecycle{
+		start: time.Now(),
+	}
+}
+
+// SetRegistered ... the service is registered in Consul
+func (lc *Lifecycle) SetRegistered(registered bool) {
+	atomic.StoreInt32(&lc.registered, boolToInt32(registered))
+}
+
+// SetDraining ... the service is shutting down
+func (lc *Lifecycle) SetDraining(draining bool) {
+	atomic.StoreInt32(&lc.draining, boolToInt32(draining))
+}
+
+// Registered ...
+func (lc *Lifecycle) Registered() bool {
+	return atomic.LoadInt32(&lc.registered) == 1
+}
+
+// Draining ...
+func (lc *Lifecycle) Draining() bool {
+	return atomic.LoadInt32(&lc.draining) == 1
+}
+
+// Uptime ...
+func (lc *Lifecycle) Uptime() time.Duration {
+	return time.Since(lc.start)
+}
+
+// InFlight ... requests being served right now
+func (lc *Lifecycle) InFlight() int64 {
+	return atomic.LoadInt64(&lc.inFlight)
+}
+
+// Track ... count the requests in flight through next
+func (lc *Lifecycle) Track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&lc.inFlight, 1)
+		defer atomic.AddInt64(&lc.inFlight, -1)
+		next.ServeHTTP(rw, r)
+	})
+}
+
+func boolToInt32(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Probe ...
+type Probe struct {
+	log   logging.Logger
+	envs  *config.Store
+	lc    *Lifecycle
+	check func(h *Probe) error
+}
+
+// HandlerLiveness ... the process is alive, unless LIVE_FAIL
+func HandlerLiveness(l logging.Logger, envs *config.Store, lc *Lifecycle) *Probe {
+	return &Probe{l, envs, lc, (*Probe).live}
+}
+
+// HandlerReadiness ... the service is started, not shutting down and its dependencies are ready
+func HandlerReadiness(l logging.Logger, envs *config.Store, lc *Lifecycle) *Probe {
+	return &Probe{l, envs, lc, (*Probe).ready}
+}
+
+// HandlerStartup ... the service has finished its STARTUP_DELAY
+func HandlerStartup(l logging.Logger, envs *config.Store, lc *Lifecycle) *Probe {
+	return &Probe{l, envs, lc, (*Probe).started}
+}
+
+// ServeHTTP ...
+func (h *Probe) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	h.log.Debug(h.envs.Get("DEBUG"), r.Method, "on", r.URL.String(), "from", r.RemoteAddr)
+
+	if r.Method != http.MethodGet {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := h.check(h); err != nil {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(rw, "Status KO: ", err.Error())
+		h.log.Debug(h.envs.Get("DEBUG"), r.URL.Path, "Status KO:", err.Error())
+		return
+	}
+	rw.WriteHeader(http.StatusOK)
+	fmt.Fprint(rw, "Status OK")
+}
+
+func (h *Probe) live() error {
+	if h.envs.Get("LIVE_FAIL") == "1" {
+		return fmt.Errorf("LIVE_FAIL")
+	}
+	return nil
+}
+
+func (h *Probe) started() error {
+	delay, _ := strconv.Atoi(h.envs.Get("STARTUP_DELAY"))
+	if uptime := h.lc.Uptime(); uptime < time.Duration(delay)*time.Second {
+		return fmt.Errorf("starting, up for %s of %ds", uptime.Round(time.Second).String(), delay)
+	}
+	return nil
+}
+
+func (h *Probe) ready() error {
+	if err := h.started(); err != nil {
+		return err
+	}
+	if h.lc.Draining() {
+		return fmt.Errorf("shutting down")
+	}
+	if h.envs.Get("READY_FAIL") == "1" {
+		return fmt.Errorf("READY_FAIL")
+	}
+
+	for _, dep := range strings.Split(h.envs.Get("READY_DEPENDENCIES"), ",") {
+		switch strings.TrimSpace(dep) {
+		case "consul":
+			if !h.lc.Registered() {
+				return fmt.Errorf("not registered in Consul")
+			}
+		case "bounce":
+			for _, target := range bounceTargets(h.envs.Get("BOUNCE_TARGETS")) {
+				if err := dialEndpoint(target, h.log, h.envs.Get("DEBUG")); err != nil {
+					return fmt.Errorf("bounce target %s not reachable", target)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// bounceTargets ... the upstreams listed in BOUNCE_TARGETS
+func bounceTargets(value string) []string {
+	var targets []string
+	for _, target := range strings.Split(value, ",") {
+		if target = strings.TrimSpace(target); len(target) != 0 {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+func validDependencies(value string) error {
+	for _, dep := range strings.Split(value, ",") {
+		if dep = strings.TrimSpace(dep); dep != "consul" && dep != "bounce" {
+			return fmt.Errorf("unknown dependency %s", dep)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/efbar/minimal-service/config"
+	"github.com/efbar/minimal-service/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProbesResp(t *testing.T) {
+	l := log.New(os.Stdout,
+		"Test Logger: ",
+		log.Ldate|log.Ltime)
+	logger := &logging.Logger{
+		Logger: l,
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	up := "http://" + listener.Addr().String()
+
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	down := "http://" + closed.Addr().String()
+	closed.Close()
+
+	tt := []struct {
+		name       string
+		probe      func(logging.Logger, *config.Store, *Lifecycle) *Probe
+		method     string
+		envs       map[string]string
+		uptime     time.Duration
+		registered bool
+		draining   bool
+		status     int
+		response   string
+	}{
+		{
+			name:     "liveness",
+			probe:    HandlerLiveness,
+			method:   "GET",
+			status:   http.StatusOK,
+			response: "Status OK",
+		},
+		{
+			name:     "liveness failing",
+			probe:    HandlerLiveness,
+			method:   "GET",
+			envs:     map[string]string{"LIVE_FAIL": "1"},
+			status:   http.StatusServiceUnavailable,
+			response: "Status KO: LIVE_FAIL",
+		},
+		{
+			name:   "liveness POST request",
+			probe:  HandlerLiveness,
+			method: "POST",
+			status: http.StatusMethodNotAllowed,
+		},
+		{
+			name:     "startup before the delay",
+			probe:    HandlerStartup,
+			method:   "GET",
+			envs:     map[string]string{"STARTUP_DELAY": "10"},
+			uptime:   5 * time.Second,
+			status:   http.StatusServiceUnavailable,
+			response: "Status KO: starting, up for 5s of 10s",
+		},
+		{
+			name:     "startup after the delay",
+			probe:    HandlerStartup,
+			method:   "GET",
+			envs:     map[string]string{"STARTUP_DELAY": "10"},
+			uptime:   11 * time.Second,
+			status:   http.StatusOK,
+			response: "Status OK",
+		},
+		{
+			name:     "readiness while starting",
+			probe:    HandlerReadiness,
+			method:   "GET",
+			envs:     map[string]string{"STARTUP_DELAY": "10"},
+			status:   http.StatusServiceUnavailable,
+			response: "Status KO: starting, up for 0s of 10s",
+		},
+		{
+			name:     "readiness while draining",
+			probe:    HandlerReadiness,
+			method:   "GET",
+			draining: true,
+			status:   http.StatusServiceUnavailable,
+			response: "Status KO: shutting down",
+		},
+		{
+			name:     "readiness failing",
+			probe:    HandlerReadiness,
+			method:   "GET",
+			envs:     map[string]string{"READY_FAIL": "1"},
+			status:   http.StatusServiceUnavailable,
+			response: "Status KO: READY_FAIL",
+		},
+		{
+			name:     "readiness without Consul registration",
+			probe:    HandlerReadiness,
+			method:   "GET",
+			envs:     map[string]string{"READY_DEPENDENCIES": "consul"},
+			status:   http.StatusServiceUnavailable,
+			response: "Status KO: not registered in Consul",
+		},
+		{
+			name:       "readiness with Consul registration",
+			probe:      HandlerReadiness,
+			method:     "GET",
+			envs:       map[string]string{"READY_DEPENDENCIES": "consul"},
+			registered: true,
+			status:     http.StatusOK,
+			response:   "Status OK",
+		},
+		{
+			name:   "readiness with reachable bounce targets",
+			probe:  HandlerReadiness,
+			method: "GET",
+			envs: map[string]string{
+				"READY_DEPENDENCIES": "bounce",
+				"BOUNCE_TARGETS":     up,
+			},
+			status:   http.StatusOK,
+			response: "Status OK",
+		},
+		{
+			name:   "readiness with a bounce target down",
+			probe:  HandlerReadiness,
+			method: "GET",
+			envs: map[string]string{
+				"READY_DEPENDENCIES": "consul,bounce",
+				"BOUNCE_TARGETS":     up + "," + down,
+			},
+			registered: true,
+			status:     http.StatusServiceUnavailable,
+			response:   "Status KO: bounce target " + down + " not reachable",
+		},
+	}
+
+	for _, tr := range tt {
+		envs := map[string]string{
+			"STARTUP_DELAY": "0",
+		}
+		for key, value := range tr.envs {
+			envs[key] = value
+		}
+		lc := NewLifecycle()
+		lc.start = time.Now().Add(-tr.uptime)
+		lc.SetRegistered(tr.registered)
+		lc.SetDraining(tr.draining)
+		handler := tr.probe(*logger, config.NewStore(envs), lc)
+
+		t.Run(tr.name, func(t *testing.T) {
+			req := httptest.NewRequest(tr.method, "/", nil)
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tr.status, rr.Result().StatusCode)
+			assert.Equal(t, tr.response, rr.Body.String())
+		})
+	}
+}
+
+func TestValidDependencies(t *testing.T) {
+	assert.NoError(t, validDependencies("consul, bounce"))
+	assert.Error(t, validDependencies("consul,database"))
+}
+
+func TestLifecycleTrack(t *testing.T) {
+	lc := NewLifecycle()
+	release := make(chan struct{})
+	served := make(chan struct{})
+	handler := lc.Track(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		served <- struct{}{}
+		<-release
+	}))
+
+	for i := 0; i < 3; i++ {
+		go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		<-served
+	}
+	assert.Equal(t, int64(3), lc.InFlight())
+
+	close(release)
+	assert.Eventually(t, func() bool { return lc.InFlight() == 0 }, time.Second, 10*time.Millisecond)
+}
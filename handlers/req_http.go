@@ -11,16 +11,16 @@ import (
 	"strings"
 	"time"
 
+	"github.com/efbar/minimal-service/config"
 	"github.com/efbar/minimal-service/helpers"
 	"github.com/efbar/minimal-service/logging"
-	tracer "github.com/efbar/minimal-service/tracer"
-	"go.opentelemetry.io/otel/label"
 )
 
 // Data ...
 type Data struct {
 	l    logging.Logger
-	envs map[string]string
+	envs *config.Store
+	mesh *Mesh
 }
 
 // JSONResponse ...
@@ -33,22 +33,52 @@ type JSONResponse struct {
 	ServedBy   string            `json:"servedBy"`
 	Method     string            `json:"method"`
 	Body       string            `json:"body,omitempty"`
+	Delay      *DelayInfo        `json:"delay,omitempty"`
+	Hops       []UpstreamResult  `json:"hops,omitempty"`
+	Targets    []UpstreamResult  `json:"targets,omitempty"`
+	Upstream   *UpstreamResponse `json:"upstream,omitempty"`
+	Trace      *TraceInfo        `json:"trace,omitempty"`
+	Caller     string            `json:"caller,omitempty"`
 }
 
-// JSONPost ...
+// JSONPost ... hops, when present, are the /bounce urls of a chain of minimal-service,
+// every instance consumes the first one and the chain ends with an empty list.
+// Method, headers, query and body shape the request to endpoint, forward lists
+// the headers of the inbound request passed through to it.
+// Endpoints are called all at once, mode tells how many of them have to succeed.
+// Response tells how the response of endpoint is sent back.
+// Timeouts, retries and backoff override the BOUNCE_* envs
 type JSONPost struct {
-	Rebound  string `json:"rebound"`
-	Endpoint string `json:"endpoint"`
+	Rebound  string            `json:"rebound"`
+	Endpoint string            `json:"endpoint,omitempty"`
+	Hops     []string          `json:"hops"`
+	Method   string            `json:"method,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Query    map[string]string `json:"query,omitempty"`
+	Body     string            `json:"body,omitempty"`
+	Forward  []string          `json:"forward,omitempty"`
+	Response string            `json:"response,omitempty"`
+
+	Endpoints  []string `json:"endpoints,omitempty"`
+	Mode       string   `json:"mode,omitempty"`
+	Quorum     int      `json:"quorum,omitempty"`
+	DeadlineMs int      `json:"deadline_ms,omitempty"`
+
+	TimeoutMs        int   `json:"timeout_ms,omitempty"`
+	OverallTimeoutMs int   `json:"overall_timeout_ms,omitempty"`
+	Retries          *int  `json:"retries,omitempty"`
+	BackoffMs        int   `json:"backoff_ms,omitempty"`
+	RetryOn          []int `json:"retry_on,omitempty"`
 }
 
 // HandlerAnyHTTP ...
-func HandlerAnyHTTP(l logging.Logger, envs map[string]string) *Data {
-	return &Data{l, envs}
+func HandlerAnyHTTP(l logging.Logger, envs *config.Store) *Data {
+	return &Data{l, envs, nil}
 }
 
-// HandlerBounceHTTP ...
-func HandlerBounceHTTP(l logging.Logger, envs map[string]string) *Data {
-	return &Data{l, envs}
+// HandlerBounceHTTP ... consul:// endpoints are called through mesh, if not nil
+func HandlerBounceHTTP(l logging.Logger, envs *config.Store, mesh *Mesh) *Data {
+	return &Data{l, envs, mesh}
 }
 
 // ServeHTTP ...
@@ -56,37 +86,67 @@ func (h *Data) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	h.l.Info(r.Method, r.URL.String(), r.RemoteAddr)
 	st := time.Now()
 
-	discarded, _ := strconv.Atoi(h.envs["DISCARD_QUOTA"])
-	rejected, _ := strconv.Atoi(h.envs["REJECT"])
-	if helpers.RandBool(discarded, &h.l) {
+	r, span := startServerSpan(r, h.l, h.envs)
+	defer span.End()
+
+	envs := h.faultEnvs(r)
+	rng := h.faultRand(r, envs)
+
+	delay, err := h.Delayer(envs, rng)
+	if err != nil {
+		h.l.Error(err.Error())
+	}
+	r = withDelay(r, delay)
+
+	discarded, _ := strconv.Atoi(envs["DISCARD_QUOTA"])
+	discard := helpers.RandBool(rng, discarded, &h.l)
+	if len(envs["FAULT_SEQUENCE"]) != 0 {
+		discard = h.sequenceStep(r, envs)
+	}
+	rejected, _ := strconv.Atoi(envs["REJECT"])
+	if discard {
 		h.l.Info("Request discarded")
 		if rejected == 1 {
+			code := rejectStatus(envs["REJECT_STATUS"], rng)
+			if after := retryAfter(envs["REJECT_RETRY_AFTER"], code); len(after) != 0 {
+				rw.Header().Set("Retry-After", after)
+			}
+			body := rejectBody(envs, code)
 			if r.Header.Get("Content-type") == "application/json" {
-				ErrorJSON(rw, "Internal Server Error", http.StatusInternalServerError)
+				ErrorJSON(rw, body, code)
 			} else {
-				http.Error(rw, "Internal Server Error", http.StatusInternalServerError)
+				http.Error(rw, body, code)
 			}
-			h.l.Debug(h.envs["DEBUG"], "Status code 500 sent")
+			h.l.Debug(envs["DEBUG"], "Status code", strconv.Itoa(code), "sent")
 			respHeaders := make(map[string]string)
 			respHeaders["Content-type"] = r.Header.Get("Content-type")
 			respHeaders["User-Agent"] = r.Header.Get("User-Agent")
 			respHeaders["FailCause"] = "request rejected"
-			h.execTracing("minimal-service", http.StatusInternalServerError, http.StatusText(500), respHeaders)
+			respHeaders["StatusCode"] = strconv.Itoa(code)
+			delayHeaders(r, respHeaders)
+			h.execTracing(r.Context(), code, http.StatusText(code), respHeaders)
+			return
 		}
+		h.connectionFault(rw, r, &st, envs)
 		return
 	}
 
+	h.serve(rw, r, &st)
+}
+
+// serve ... dispatch the request by method and path
+func (h *Data) serve(rw http.ResponseWriter, r *http.Request, st *time.Time) {
 	if r.Method == http.MethodGet {
-		h.simpleServe(rw, r, &st)
+		h.simpleServe(rw, r, st)
 	} else if r.Method == http.MethodPost && r.RequestURI == "/bounce" {
-		if err := h.reboundServe(rw, r, &st); err != nil {
+		if err := h.reboundServe(rw, r, st); err != nil {
 			h.l.Error(err.Error())
 		}
 	} else {
 		rw.WriteHeader(http.StatusMethodNotAllowed)
 		respHeaders := make(map[string]string)
 		respHeaders["URI"] = r.RequestURI
-		h.execTracing("minimal-service", http.StatusMethodNotAllowed, http.StatusText(405), respHeaders)
+		h.execTracing(r.Context(), http.StatusMethodNotAllowed, http.StatusText(405), respHeaders)
 	}
 }
 
@@ -104,13 +164,6 @@ func (h *Data) simpleServe(rw http.ResponseWriter, r *http.Request, st *time.Tim
 	} else {
 		rw.Header().Set("Content-Type", "application/json")
 
-		delayEnv := h.envs["DELAY_MAX"]
-		if len(delayEnv) != 0 && delayEnv != "0" {
-			if err := h.Delayer(delayEnv); err != nil {
-				h.l.Error(err.Error())
-			}
-		}
-
 		js, err := h.shapingJSON(r, st)
 		if err != nil {
 			h.l.Error("error shaping json", err.Error())
@@ -123,7 +176,7 @@ func (h *Data) simpleServe(rw http.ResponseWriter, r *http.Request, st *time.Tim
 			return
 		}
 
-		h.execTracing("minimal-service", http.StatusOK, http.StatusText(200), js.Headers)
+		h.execTracing(r.Context(), http.StatusOK, http.StatusText(200), js.Headers)
 
 	}
 
@@ -137,14 +190,6 @@ func (h *Data) reboundServe(rw http.ResponseWriter, r *http.Request, st *time.Ti
 	body, _ := ioutil.ReadAll(r.Body)
 	defer r.Body.Close()
 
-	delayEnv := h.envs["DELAY_MAX"]
-	if len(delayEnv) != 0 && delayEnv != "0" {
-		if err := h.Delayer(delayEnv); err != nil {
-			h.l.Error(err.Error())
-			return err
-		}
-	}
-
 	jsonRecived := &JSONPost{}
 
 	if err := DecodeJSON(body, jsonRecived, rw); err != nil {
@@ -153,25 +198,57 @@ func (h *Data) reboundServe(rw http.ResponseWriter, r *http.Request, st *time.Ti
 	}
 
 	if jsonRecived.Rebound == "true" {
-		h.l.Debug(h.envs["DEBUG"], "jsonRecived.Rebound", jsonRecived.Rebound)
-		if err := h.rawConnect(jsonRecived.Endpoint); err != nil {
-			http.Error(rw, "Bad Gateway", http.StatusBadGateway)
-			respHeaders := make(map[string]string)
-			respHeaders["Content-type"] = r.Header.Get("Content-type")
-			respHeaders["User-Agent"] = r.Header.Get("User-Agent")
-			respHeaders["FailCause"] = "Bad Gateway"
-			h.execTracing("minimal-service", http.StatusBadGateway, http.StatusText(502), respHeaders)
+		h.l.Debug(h.envs.Get("DEBUG"), "jsonRecived.Rebound", jsonRecived.Rebound)
+		if err := validResponseMode(jsonRecived.Response); err != nil {
+			ErrorJSON(rw, err.Error(), http.StatusBadRequest)
 			return err
 		}
-
-		resp, err := http.Get(jsonRecived.Endpoint)
+		if jsonRecived.Hops != nil {
+			return h.hopServe(rw, r, st, body, jsonRecived)
+		}
+		if len(jsonRecived.Endpoints) != 0 {
+			return h.fanoutServe(rw, r, st, body, jsonRecived)
+		}
+		policy, err := h.retryPolicy(jsonRecived)
 		if err != nil {
-			http.Error(rw, "Bad Gateway", http.StatusBadGateway)
+			ErrorJSON(rw, err.Error(), http.StatusBadRequest)
 			return err
 		}
+		newRequest := func() (*http.Request, error) {
+			return newUpstreamRequest(r, jsonRecived)
+		}
+		if _, err := newRequest(); err != nil {
+			http.Error(rw, "Bad Request", http.StatusBadRequest)
+			return err
+		}
+
+		call := h.callUpstream(r.Context(), jsonRecived.Endpoint, policy, newRequest)
+		rw.Header().Set("X-Bounce-Attempts", strconv.Itoa(call.attempts))
+		rw.Header().Set("X-Bounce-Breaker", call.breaker)
+		if call.err != nil {
+			code := http.StatusBadGateway
+			if call.err == errBreakerOpen {
+				code = http.StatusServiceUnavailable
+			}
+			http.Error(rw, http.StatusText(code), code)
+			respHeaders := make(map[string]string)
+			respHeaders["Content-type"] = r.Header.Get("Content-type")
+			respHeaders["User-Agent"] = r.Header.Get("User-Agent")
+			respHeaders["FailCause"] = http.StatusText(code)
+			h.execTracing(r.Context(), code, http.StatusText(code), respHeaders)
+			return call.err
+		}
+		resp := call.resp
 		defer resp.Body.Close()
 		h.l.Info(resp.Status, jsonRecived.Endpoint)
 
+		switch jsonRecived.Response {
+		case "nested":
+			return h.nestedServe(rw, r, st, body, call)
+		case "transparent":
+			return h.transparentServe(rw, r, resp)
+		}
+
 		r.Header = resp.Header
 		r.Body = ioutil.NopCloser(strings.NewReader(resp.Status))
 
@@ -187,7 +264,7 @@ func (h *Data) reboundServe(rw http.ResponseWriter, r *http.Request, st *time.Ti
 			return err
 		}
 
-		h.execTracing("minimal-service", http.StatusOK, http.StatusText(200), js.Headers)
+		h.execTracing(r.Context(), http.StatusOK, http.StatusText(200), js.Headers)
 
 	}
 	return nil
@@ -195,25 +272,30 @@ func (h *Data) reboundServe(rw http.ResponseWriter, r *http.Request, st *time.Ti
 }
 
 func (h *Data) rawConnect(endpoint string) error {
+	return dialEndpoint(endpoint, h.l, h.envs.Get("DEBUG"))
+}
+
+// dialEndpoint ... check that the host of endpoint resolves and accepts tcp connections
+func dialEndpoint(endpoint string, l logging.Logger, debug string) error {
 	timeout := time.Second
 
 	url, err := url.ParseRequestURI(endpoint)
 	if err != nil {
-		h.l.Error("Wrong url")
+		l.Error("Wrong url")
 		return err
 	}
-	h.l.Debug(h.envs["DEBUG"], "Correct url:", url.String())
+	l.Debug(debug, "Correct url:", url.String())
 
 	host := url.Hostname()
 	port := url.Port()
-	h.l.Debug(h.envs["DEBUG"], "Splitted url:", host, port)
+	l.Debug(debug, "Splitted url:", host, port)
 
 	s, err := net.ResolveIPAddr("ip", host)
 	if err != nil {
-		h.l.Error("Resolve Error:", err.Error())
+		l.Error("Resolve Error:", err.Error())
 		return err
 	}
-	h.l.Debug(h.envs["DEBUG"], "Resolved url:", url.Scheme, s.String())
+	l.Debug(debug, "Resolved url:", url.Scheme, s.String())
 
 	if port == "" {
 		if url.Scheme == "http" {
@@ -223,14 +305,14 @@ func (h *Data) rawConnect(endpoint string) error {
 		}
 	}
 
-	h.l.Debug(h.envs["DEBUG"], "Before dial:", host, port)
+	l.Debug(debug, "Before dial:", host, port)
 	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), timeout)
 	if err != nil {
-		h.l.Error("Connection Error:", err.Error())
+		l.Error("Connection Error:", err.Error())
 		return err
 	}
 	if conn != nil {
-		h.l.Debug(h.envs["DEBUG"], "Open:", net.JoinHostPort(host, port))
+		l.Debug(debug, "Open:", net.JoinHostPort(host, port))
 		defer conn.Close()
 	}
 
@@ -252,7 +334,7 @@ func (h *Data) shapingJSON(r *http.Request, st *time.Time) (*JSONResponse, error
 		"Response-time": ft.UTC().String(),
 		"Duration":      fmt.Sprint(float64(delta) / float64(time.Millisecond)),
 	}
-	headers := CollectHeaders(r, serverTiming)
+	headers := CollectHeaders(r, delayHeaders(r, serverTiming))
 	js := &JSONResponse{
 		Host:       r.Host,
 		StatusCode: http.StatusOK,
@@ -262,6 +344,9 @@ func (h *Data) shapingJSON(r *http.Request, st *time.Time) (*JSONResponse, error
 		RequestURI: string(r.RequestURI),
 		ServedBy:   host,
 		Method:     string(r.Method),
+		Delay:      delayFrom(r),
+		Trace:      traceFrom(r),
+		Caller:     callerID(r),
 	}
 
 	return js, err
@@ -284,7 +369,7 @@ func (h *Data) shapingPlain(rw http.ResponseWriter, r *http.Request, st *time.Ti
 		"ResponseTime": ft.UTC().String(),
 		"Duration":     fmt.Sprint(float64(delta) / float64(time.Millisecond)),
 	}
-	headers := CollectHeaders(r, serverTiming)
+	headers := CollectHeaders(r, delayHeaders(r, serverTiming))
 	for key, value := range headers {
 		fmt.Fprintf(rw, "%s: %s\n", key, value)
 	}
@@ -292,30 +377,7 @@ func (h *Data) shapingPlain(rw http.ResponseWriter, r *http.Request, st *time.Ti
 	fmt.Fprintln(rw, "")
 	io.Copy(rw, r.Body)
 
-	h.execTracing("minimal-service", http.StatusOK, http.StatusText(200), headers)
+	h.execTracing(r.Context(), http.StatusOK, http.StatusText(200), headers)
 
 	return err
 }
-
-func (h *Data) execTracing(service string, code int, message string, headers map[string]string) {
-	enableTracing := h.envs["TRACING"]
-	if enableTracing == "1" {
-		jaegerURL := h.envs["JAEGER_URL"]
-		host, _ := helpers.GetHostname()
-
-		t := &tracer.TraceObject{}
-		tags := []label.KeyValue{
-			label.String("Exporter", "opentracing-jaeger-plugin"),
-			label.String("Hostname", host),
-		}
-
-		for key, value := range headers {
-			tags = append(tags, label.String(key, value))
-		}
-		err := t.Opentracer(jaegerURL, service, code, message, tags, &h.l, h.envs)
-
-		if err != nil {
-			h.l.Error("TRACING, ", err.Error())
-		}
-	}
-}
@@ -11,6 +11,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/efbar/minimal-service/config"
 	"github.com/efbar/minimal-service/helpers"
 	"github.com/efbar/minimal-service/logging"
 	"github.com/stretchr/testify/assert"
@@ -26,7 +27,8 @@ func setupReqHTTPTest(t *testing.T) *Data {
 
 	return &Data{
 		*logger,
-		helpers.ListEnvs,
+		config.NewStore(helpers.ListEnvs),
+		nil,
 	}
 }
 
@@ -198,12 +200,12 @@ func TestReqHTTPResp(t *testing.T) {
 		handler := setupReqHTTPTest(t)
 
 		if len(tr.envs) == 0 {
-			handler.envs = map[string]string{
+			handler.envs = config.NewStore(map[string]string{
 				"DELAY_MAX": "0",
 				"TRACING":   "0",
-			}
+			})
 		} else {
-			handler.envs = tr.envs
+			handler.envs = config.NewStore(tr.envs)
 		}
 
 		t.Run(tr.name, func(t *testing.T) {
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// faultRule ... faults applied to the requests matching every condition of the rule
+type faultRule struct {
+	Name       string            `json:"name"`
+	PathPrefix string            `json:"pathPrefix"`
+	PathRegex  string            `json:"pathRegex"`
+	Methods    []string          `json:"methods"`
+	Headers    map[string]string `json:"headers"`
+	Query      map[string]string `json:"query"`
+	Envs       map[string]string `json:"envs"`
+
+	regex *regexp.Regexp
+}
+
+// rules validate their envs too, so their validator is added here
+func init() {
+	envValidators["FAULT_RULES"] = validRules
+}
+
+// rulesCache ... last FAULT_RULES parsed, they change only through the admin API
+var rulesCache struct {
+	sync.Mutex
+	raw   string
+	rules []faultRule
+	err   error
+}
+
+// parseRules ... parse the FAULT_RULES json list
+func parseRules(raw string) ([]faultRule, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	rulesCache.Lock()
+	defer rulesCache.Unlock()
+	if raw == rulesCache.raw {
+		return rulesCache.rules, rulesCache.err
+	}
+
+	rules, err := compileRules(raw)
+	rulesCache.raw, rulesCache.rules, rulesCache.err = raw, rules, err
+
+	return rules, err
+}
+
+func compileRules(raw string) ([]faultRule, error) {
+	var rules []faultRule
+	if err := json.Unmarshal([]byte(raw), &rules); err != nil {
+		return nil, err
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		if len(rule.Name) == 0 {
+			rule.Name = fmt.Sprintf("rule-%d", i)
+		}
+		if len(rule.PathRegex) != 0 {
+			regex, err := regexp.Compile(rule.PathRegex)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: %s", rule.Name, err.Error())
+			}
+			rule.regex = regex
+		}
+		if _, ok := rule.Envs["FAULT_RULES"]; ok {
+			return nil, fmt.Errorf("rule %s: rules can't set FAULT_RULES", rule.Name)
+		}
+		if err := ValidateEnvs(rule.Envs); err != nil {
+			return nil, fmt.Errorf("rule %s: %s", rule.Name, err.Error())
+		}
+	}
+
+	return rules, nil
+}
+
+// matches ... true if the request matches every condition of the rule
+func (rule *faultRule) matches(r *http.Request) bool {
+	if !strings.HasPrefix(r.URL.Path, rule.PathPrefix) {
+		return false
+	}
+	if rule.regex != nil && !rule.regex.MatchString(r.URL.Path) {
+		return false
+	}
+	if len(rule.Methods) != 0 {
+		found := false
+		for _, method := range rule.Methods {
+			found = found || strings.EqualFold(method, r.Method)
+		}
+		if !found {
+			return false
+		}
+	}
+	for key, value := range rule.Headers {
+		if r.Header.Get(key) != value {
+			return false
+		}
+	}
+	query := r.URL.Query()
+	for key, value := range rule.Query {
+		if query.Get(key) != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+func validRules(value string) error {
+	_, err := compileRules(value)
+	return err
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/efbar/minimal-service/config"
+	"github.com/stretchr/testify/assert"
+)
+
+const testRules = `[
+	{"name":"canary","headers":{"X-Canary":"true"},"envs":{"REJECT_STATUS":"503"}},
+	{"name":"flaky-bounce","pathPrefix":"/bounce","methods":["post"],"envs":{"REJECT_STATUS":"502"}},
+	{"name":"debug-query","pathRegex":"^/items/[0-9]+$","query":{"fail":"yes"},"envs":{"REJECT_STATUS":"429"}},
+	{"name":"clean-items","pathPrefix":"/items","envs":{"DISCARD_QUOTA":"0"}}
+]`
+
+func TestFaultRules(t *testing.T) {
+	tt := []struct {
+		name    string
+		method  string
+		path    string
+		headers map[string]string
+		status  int
+	}{
+		{
+			name:   "no rule matches",
+			method: "GET",
+			path:   "/",
+			status: http.StatusOK,
+		},
+		{
+			name:    "header rule",
+			method:  "GET",
+			path:    "/",
+			headers: map[string]string{"X-Canary": "true"},
+			status:  http.StatusServiceUnavailable,
+		},
+		{
+			name:    "header rule, other value",
+			method:  "GET",
+			path:    "/",
+			headers: map[string]string{"X-Canary": "false"},
+			status:  http.StatusOK,
+		},
+		{
+			name:   "path and method rule",
+			method: "POST",
+			path:   "/bounce",
+			status: http.StatusBadGateway,
+		},
+		{
+			name:   "path rule, other method",
+			method: "GET",
+			path:   "/bounce",
+			status: http.StatusOK,
+		},
+		{
+			name:   "regex and query rule",
+			method: "GET",
+			path:   "/items/42?fail=yes",
+			status: http.StatusTooManyRequests,
+		},
+		{
+			name:   "regex rule without query",
+			method: "GET",
+			path:   "/items/42",
+			status: http.StatusOK,
+		},
+		{
+			name:    "rules are evaluated in order",
+			method:  "POST",
+			path:    "/bounce",
+			headers: map[string]string{"X-Canary": "true"},
+			status:  http.StatusServiceUnavailable,
+		},
+		{
+			name:    "fault headers win over rules",
+			method:  "GET",
+			path:    "/",
+			headers: map[string]string{"X-Canary": "true", "X-Fault-Abort-Percent": "0"},
+			status:  http.StatusOK,
+		},
+	}
+
+	for _, tr := range tt {
+		t.Run(tr.name, func(t *testing.T) {
+			handler := setupReqHTTPTest(t)
+			handler.envs = config.NewStore(map[string]string{
+				"DELAY_MAX":   "0",
+				"TRACING":     "0",
+				"FAULT_RULES": testRules,
+			})
+
+			req := httptest.NewRequest(tr.method, tr.path, strings.NewReader("{}"))
+			for key, value := range tr.headers {
+				req.Header.Set(key, value)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tr.status, rr.Code)
+		})
+	}
+}
+
+func TestFaultRulesValidation(t *testing.T) {
+	assert.NoError(t, validRules(testRules))
+	assert.Error(t, validRules(`{"name":"not a list"}`))
+	assert.Error(t, validRules(`[{"pathRegex":"(("}]`))
+	assert.Error(t, validRules(`[{"envs":{"SERVICE_PORT":"80"}}]`))
+	assert.Error(t, validRules(`[{"envs":{"DISCARD_QUOTA":"all"}}]`))
+	assert.Error(t, validRules(`[{"envs":{"FAULT_RULES":"[]"}}]`))
+
+	handler := setupReqHTTPTest(t)
+	handler.envs = config.NewStore(map[string]string{
+		"TRACING":     "0",
+		"FAULT_RULES": `[{"pathRegex":"(("}]`,
+	})
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
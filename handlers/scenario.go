@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/efbar/minimal-service/config"
+	"github.com/efbar/minimal-service/logging"
+	"github.com/efbar/minimal-service/scenario"
+)
+
+// ScenarioStatus ...
+type ScenarioStatus struct {
+	log    logging.Logger
+	envs   *config.Store
+	player *scenario.Player
+}
+
+// HandlerScenario ... player can be nil if no scenario is loaded
+func HandlerScenario(l logging.Logger, envs *config.Store, player *scenario.Player) *ScenarioStatus {
+	return &ScenarioStatus{
+		log:    l,
+		envs:   envs,
+		player: player,
+	}
+}
+
+// ServeHTTP ... current phase of the scenario
+func (h *ScenarioStatus) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	h.log.Debug(h.envs.Get("DEBUG"), r.Method, "on", r.URL.String(), "from", r.RemoteAddr)
+
+	if r.Method != http.MethodGet {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if h.player == nil {
+		ErrorJSON(rw, "No scenario loaded", http.StatusNotFound)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(h.player.Status()); err != nil {
+		h.log.Error("error encoding json", err.Error())
+	}
+}
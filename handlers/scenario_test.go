@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/efbar/minimal-service/config"
+	"github.com/efbar/minimal-service/logging"
+	"github.com/efbar/minimal-service/scenario"
+	"github.com/stretchr/testify/assert"
+)
+
+const testScenario = `
+name: drill
+loop: true
+phases:
+  - name: healthy
+    duration: 100ms
+  - name: degraded
+    duration: 100ms
+    envs:
+      DISCARD_QUOTA: "100"
+      REJECT: "1"
+      REJECT_STATUS: "503"
+  - name: unhealthy
+    duration: 100ms
+    envs:
+      HEALTH_FAIL: "1"
+`
+
+func setupScenarioTest(t *testing.T, name string, content string) (*scenario.Scenario, logging.Logger) {
+	l := log.New(os.Stdout,
+		"Test Logger: ",
+		log.Ldate|log.Ltime)
+	logger := &logging.Logger{
+		Logger: l,
+	}
+
+	file := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sc, err := scenario.Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sc, *logger
+}
+
+func TestScenarioPlay(t *testing.T) {
+	sc, logger := setupScenarioTest(t, "drill.yaml", testScenario)
+	store := config.NewStore(map[string]string{
+		"DELAY_MAX": "0",
+		"TRACING":   "0",
+	})
+	player := scenario.NewPlayer(sc, store, logger)
+
+	data := setupReqHTTPTest(t)
+	data.envs = store
+	health := HandlerHealth(logger, store)
+	status := HandlerScenario(logger, store, player)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		player.Run(ctx)
+		close(done)
+	}()
+
+	expected := []struct {
+		phase  string
+		data   int
+		health int
+	}{
+		{"healthy", http.StatusOK, http.StatusOK},
+		{"degraded", http.StatusServiceUnavailable, http.StatusOK},
+		{"unhealthy", http.StatusOK, http.StatusServiceUnavailable},
+		{"healthy", http.StatusOK, http.StatusOK},
+	}
+	// the phase can end between the requests, so they are sent again until
+	// all of them see the same phase
+	for i, exp := range expected {
+		assert.Eventually(t, func() bool {
+			rr := httptest.NewRecorder()
+			status.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/scenario", nil))
+			st := scenario.Status{}
+			if rr.Code != http.StatusOK || json.NewDecoder(rr.Body).Decode(&st) != nil {
+				return false
+			}
+			if !st.Running || st.Phase != exp.phase || st.Iteration != i/3 {
+				return false
+			}
+
+			rr = httptest.NewRecorder()
+			data.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+			dataCode := rr.Code
+
+			rr = httptest.NewRecorder()
+			health.ServeHTTP(rr, httptest.NewRequest("GET", "/health", nil))
+
+			return dataCode == exp.data && rr.Code == exp.health && player.Status().Phase == exp.phase
+		}, 5*time.Second, 10*time.Millisecond, exp.phase)
+	}
+
+	cancel()
+	<-done
+	assert.False(t, player.Status().Running)
+	assert.Equal(t, "", store.Get("REJECT"))
+}
+
+func TestScenarioNotLoaded(t *testing.T) {
+	_, logger := setupScenarioTest(t, "drill.yaml", testScenario)
+	handler := HandlerScenario(logger, config.NewStore(nil), nil)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/scenario", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/admin/scenario", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/efbar/minimal-service/helpers"
+)
+
+// unseeded ... random generator used when FAULT_SEED is not set
+var unseeded = helpers.NewRand(time.Now().UnixNano())
+
+// seeded ... random generator of the current FAULT_SEED, shared by all the handlers
+// so that the same sequence of requests gets the same faults, it restarts when the seed changes
+var seeded struct {
+	sync.Mutex
+	seed string
+	rng  *rand.Rand
+}
+
+// faultRand ... random generator for the fault decisions on the request.
+// With FAULT_HASH_HEADER it's derived from the header value, so the same request always
+// gets the same faults, otherwise it's the FAULT_SEED one or an unseeded one.
+func (h *Data) faultRand(r *http.Request, envs map[string]string) *rand.Rand {
+	seed := envs["FAULT_SEED"]
+
+	if header := envs["FAULT_HASH_HEADER"]; len(header) != 0 {
+		if value := r.Header.Get(header); len(value) != 0 {
+			hash := fnv.New64a()
+			hash.Write([]byte(seed))
+			hash.Write([]byte{0})
+			hash.Write([]byte(value))
+			h.l.Debug(envs["DEBUG"], "Fault decisions from", header, value)
+			return rand.New(rand.NewSource(int64(hash.Sum64())))
+		}
+	}
+
+	if len(seed) == 0 {
+		return unseeded
+	}
+
+	seeded.Lock()
+	defer seeded.Unlock()
+	if seeded.rng == nil || seeded.seed != seed {
+		n, err := strconv.ParseInt(seed, 10, 64)
+		if err != nil {
+			hash := fnv.New64a()
+			hash.Write([]byte(seed))
+			n = int64(hash.Sum64())
+		}
+		seeded.seed = seed
+		seeded.rng = helpers.NewRand(n)
+		h.l.Info("Fault decisions seeded with", seed)
+	}
+
+	return seeded.rng
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/efbar/minimal-service/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func seededOutcomes(t *testing.T, envs map[string]string, requestIDs []string) []int {
+	handler := setupReqHTTPTest(t)
+	handler.envs = config.NewStore(envs)
+
+	var codes []int
+	for _, id := range requestIDs {
+		req := httptest.NewRequest("GET", "/", nil)
+		if len(id) != 0 {
+			req.Header.Set("X-Request-ID", id)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		codes = append(codes, rr.Code)
+	}
+
+	return codes
+}
+
+func TestSeededFaults(t *testing.T) {
+	// start from a fresh generator, as a new process would
+	seeded.Lock()
+	seeded.rng = nil
+	seeded.Unlock()
+
+	envs := map[string]string{
+		"TRACING":       "0",
+		"DISCARD_QUOTA": "50",
+		"REJECT":        "1",
+		"REJECT_STATUS": "503:1,429:1",
+		"FAULT_SEED":    "42",
+	}
+	requests := make([]string, 12)
+
+	first := seededOutcomes(t, envs, requests)
+	envs["FAULT_SEED"] = "7"
+	seededOutcomes(t, envs, requests)
+	envs["FAULT_SEED"] = "42"
+	second := seededOutcomes(t, envs, requests)
+
+	assert.Equal(t, first, second)
+	assert.Equal(t, []int{429, 200, 429, 429, 503, 200, 200, 429, 503, 503, 200, 200}, first)
+}
+
+func TestHashedFaults(t *testing.T) {
+	envs := map[string]string{
+		"TRACING":           "0",
+		"DISCARD_QUOTA":     "50",
+		"REJECT":            "1",
+		"FAULT_HASH_HEADER": "X-Request-ID",
+	}
+	requests := make([]string, 20)
+	for i := range requests {
+		requests[i] = "req-" + strconv.Itoa(i)
+	}
+
+	first := seededOutcomes(t, envs, requests)
+	for i, j := 0, len(requests)-1; i < j; i, j = i+1, j-1 {
+		requests[i], requests[j] = requests[j], requests[i]
+	}
+	reversed := seededOutcomes(t, envs, requests)
+	for i, j := 0, len(reversed)-1; i < j; i, j = i+1, j-1 {
+		reversed[i], reversed[j] = reversed[j], reversed[i]
+	}
+
+	assert.Equal(t, first, reversed)
+	assert.Contains(t, first, http.StatusOK)
+	assert.Contains(t, first, http.StatusInternalServerError)
+
+	envs["FAULT_SEED"] = "42"
+	assert.Equal(t, seededOutcomes(t, envs, []string{"req-3", "req-3", "req-3"})[0],
+		seededOutcomes(t, envs, []string{"req-3"})[0])
+}
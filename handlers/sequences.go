@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/efbar/minimal-service/config"
+	"github.com/efbar/minimal-service/logging"
+)
+
+// sequenceCounter ... requests seen by a failure sequence for a key
+type sequenceCounter struct {
+	Key      string `json:"key"`
+	Sequence string `json:"sequence"`
+	Count    int    `json:"count"`
+	id       string
+}
+
+// maxSequenceCounters ... counters kept at most, see evict
+var maxSequenceCounters = 10000
+
+// sequenceTracker ... counters of the failure sequences, shared by all the handlers,
+// recent holds them from the most to the least recently used
+type sequenceTracker struct {
+	mu       sync.Mutex
+	counters map[string]*list.Element
+	recent   *list.List
+}
+
+var sequences = newSequenceTracker()
+
+// newSequenceTracker ...
+func newSequenceTracker() *sequenceTracker {
+	return &sequenceTracker{
+		counters: map[string]*list.Element{},
+		recent:   list.New(),
+	}
+}
+
+// next ... count a request for the key and return how many came before it
+func (s *sequenceTracker) next(sequence string, key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := sequence + "\x00" + key
+	elem, ok := s.counters[id]
+	if ok {
+		s.recent.MoveToFront(elem)
+	} else {
+		if len(s.counters) >= maxSequenceCounters {
+			s.evict()
+		}
+		elem = s.recent.PushFront(&sequenceCounter{Key: key, Sequence: sequence, id: id})
+		s.counters[id] = elem
+	}
+	counter := elem.Value.(*sequenceCounter)
+	counter.Count++
+
+	return counter.Count - 1
+}
+
+// evict ... drop the least recently used counter to make room for a new key
+func (s *sequenceTracker) evict() {
+	if oldest := s.recent.Back(); oldest != nil {
+		s.recent.Remove(oldest)
+		delete(s.counters, oldest.Value.(*sequenceCounter).id)
+	}
+}
+
+// list ... all the counters, sorted by key
+func (s *sequenceTracker) list() []sequenceCounter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]sequenceCounter, 0, len(s.counters))
+	for elem := s.recent.Front(); elem != nil; elem = elem.Next() {
+		list = append(list, *elem.Value.(*sequenceCounter))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Key != list[j].Key {
+			return list[i].Key < list[j].Key
+		}
+		return list[i].Sequence < list[j].Sequence
+	})
+
+	return list
+}
+
+// reset ... drop the counters of the key, or all of them if key is empty
+func (s *sequenceTracker) reset(key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for id, elem := range s.counters {
+		if len(key) == 0 || elem.Value.(*sequenceCounter).Key == key {
+			s.recent.Remove(elem)
+			delete(s.counters, id)
+			n++
+		}
+	}
+
+	return n
+}
+
+// parseSequence ... parse a sequence like "503,503,200" or "500*3,reset,200",
+// every step is a status code or a discard mode, repeated N times with *N
+func parseSequence(value string) ([]string, error) {
+	var steps []string
+	for _, elem := range strings.Split(value, ",") {
+		pair := strings.SplitN(strings.TrimSpace(elem), "*", 2)
+		step := pair[0]
+		if code, err := strconv.Atoi(step); err == nil {
+			if code < 200 || code > 599 {
+				return nil, fmt.Errorf("status code %d out of range", code)
+			}
+		} else if err := validDiscardMode(step); err != nil {
+			return nil, fmt.Errorf("step %s is not a status code or a discard mode", step)
+		}
+		times := 1
+		if len(pair) == 2 {
+			n, err := strconv.Atoi(pair[1])
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("not valid repetition in %s", elem)
+			}
+			times = n
+		}
+		for i := 0; i < times; i++ {
+			steps = append(steps, step)
+		}
+	}
+
+	return steps, nil
+}
+
+// sequenceKey ... key of the request counters, from FAULT_SEQUENCE_KEY:
+// global (default), path or header:<name>
+func sequenceKey(r *http.Request, envs map[string]string) string {
+	key := envs["FAULT_SEQUENCE_KEY"]
+	switch {
+	case key == "path":
+		return r.URL.Path
+	case strings.HasPrefix(key, "header:"):
+		name := strings.TrimPrefix(key, "header:")
+		return name + "=" + r.Header.Get(name)
+	default:
+		return "global"
+	}
+}
+
+// sequenceStep ... next step of FAULT_SEQUENCE for the request, true if it has to fail.
+// envs are set to fail as the step says, a 2xx step or the end of the sequence means success.
+func (h *Data) sequenceStep(r *http.Request, envs map[string]string) bool {
+	steps, err := parseSequence(envs["FAULT_SEQUENCE"])
+	if err != nil {
+		h.l.Error("FAULT_SEQUENCE,", err.Error())
+		return false
+	}
+
+	key := sequenceKey(r, envs)
+	n := sequences.next(envs["FAULT_SEQUENCE"], key)
+	if n >= len(steps) {
+		if envs["FAULT_SEQUENCE_LOOP"] != "1" {
+			return false
+		}
+		n = n % len(steps)
+	}
+	step := steps[n]
+	h.l.Debug(envs["DEBUG"], "Sequence", key, "step", strconv.Itoa(n), step)
+
+	code, err := strconv.Atoi(step)
+	if err != nil {
+		envs["REJECT"] = "0"
+		envs["DISCARD_MODE"] = step
+		return true
+	}
+	if code < 300 {
+		return false
+	}
+	envs["REJECT"] = "1"
+	envs["REJECT_STATUS"] = step
+
+	return true
+}
+
+func validSequence(value string) error {
+	_, err := parseSequence(value)
+	return err
+}
+
+func validSequenceKey(value string) error {
+	if value != "global" && value != "path" && !strings.HasPrefix(value, "header:") {
+		return fmt.Errorf("sequence key must be global, path or header:<name>")
+	}
+	return nil
+}
+
+// Sequences ...
+type Sequences struct {
+	log  logging.Logger
+	envs *config.Store
+}
+
+// HandlerSequences ...
+func HandlerSequences(l logging.Logger, envs *config.Store) *Sequences {
+	return &Sequences{
+		log:  l,
+		envs: envs,
+	}
+}
+
+// ServeHTTP ... GET lists the sequence counters, DELETE resets them, all or only the ones of ?key=
+func (h *Sequences) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	h.log.Debug(h.envs.Get("DEBUG"), r.Method, "on", r.URL.String(), "from", r.RemoteAddr)
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodDelete:
+		key := r.URL.Query().Get("key")
+		n := sequences.reset(key)
+		h.log.Info("Admin:", strconv.Itoa(n), "sequence counters reset by", r.RemoteAddr)
+	default:
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(sequences.list()); err != nil {
+		h.log.Error("error encoding json", err.Error())
+	}
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/efbar/minimal-service/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFaultSequences(t *testing.T) {
+	tt := []struct {
+		name     string
+		envs     map[string]string
+		clients  []string
+		paths    []string
+		statuses []int
+	}{
+		{
+			name:     "fail twice then succeed",
+			envs:     map[string]string{"FAULT_SEQUENCE": "503,503,200"},
+			statuses: []int{503, 503, 200, 200, 200},
+		},
+		{
+			name:     "fail the first N requests",
+			envs:     map[string]string{"FAULT_SEQUENCE": "500*3"},
+			statuses: []int{500, 500, 500, 200},
+		},
+		{
+			name:     "loop",
+			envs:     map[string]string{"FAULT_SEQUENCE": "429,200", "FAULT_SEQUENCE_LOOP": "1"},
+			statuses: []int{429, 200, 429, 200},
+		},
+		{
+			name:     "sequence wins over random discards",
+			envs:     map[string]string{"FAULT_SEQUENCE": "200,502", "DISCARD_QUOTA": "100", "REJECT": "1"},
+			statuses: []int{200, 502, 200},
+		},
+		{
+			name:     "key from header",
+			envs:     map[string]string{"FAULT_SEQUENCE": "503,200", "FAULT_SEQUENCE_KEY": "header:X-Client-ID"},
+			clients:  []string{"a", "b", "a", "b", "c"},
+			statuses: []int{503, 503, 200, 200, 503},
+		},
+		{
+			name:     "key from path",
+			envs:     map[string]string{"FAULT_SEQUENCE": "504,200", "FAULT_SEQUENCE_KEY": "path"},
+			paths:    []string{"/a", "/a", "/b"},
+			statuses: []int{504, 200, 504},
+		},
+		{
+			name:     "not valid sequence is ignored",
+			envs:     map[string]string{"FAULT_SEQUENCE": "503,boom"},
+			statuses: []int{200, 200},
+		},
+	}
+
+	for _, tr := range tt {
+		t.Run(tr.name, func(t *testing.T) {
+			sequences.reset("")
+			envs := map[string]string{"TRACING": "0"}
+			for key, value := range tr.envs {
+				envs[key] = value
+			}
+			handler := setupReqHTTPTest(t)
+			handler.envs = config.NewStore(envs)
+
+			for i, status := range tr.statuses {
+				path := "/"
+				if len(tr.paths) != 0 {
+					path = tr.paths[i]
+				}
+				req := httptest.NewRequest("GET", path, nil)
+				if len(tr.clients) != 0 {
+					req.Header.Set("X-Client-ID", tr.clients[i])
+				}
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, req)
+				assert.Equal(t, status, rr.Code, "request %d", i)
+			}
+		})
+	}
+}
+
+func TestSequencesResp(t *testing.T) {
+	sequences.reset("")
+	store := config.NewStore(map[string]string{
+		"TRACING":            "0",
+		"FAULT_SEQUENCE":     "503,200",
+		"FAULT_SEQUENCE_KEY": "header:X-Client-ID",
+	})
+	data := setupReqHTTPTest(t)
+	data.envs = store
+	handler := HandlerSequences(data.l, store)
+
+	for _, client := range []string{"a", "a", "b"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Client-ID", client)
+		data.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/sequences", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var counters []sequenceCounter
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&counters))
+	assert.Equal(t, []sequenceCounter{
+		{Key: "X-Client-ID=a", Sequence: "503,200", Count: 2},
+		{Key: "X-Client-ID=b", Sequence: "503,200", Count: 1},
+	}, counters)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("DELETE", "/admin/sequences?key=X-Client-ID=a", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	counters = nil
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&counters))
+	assert.Len(t, counters, 1)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Client-ID", "a")
+	rr = httptest.NewRecorder()
+	data.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("DELETE", "/admin/sequences", nil))
+	counters = nil
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&counters))
+	assert.Len(t, counters, 0)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/admin/sequences", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
+
+func TestParseSequence(t *testing.T) {
+	steps, err := parseSequence("503*2, reset,200")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"503", "503", "reset", "200"}, steps)
+
+	for _, value := range []string{"", "99", "503*0", "503*x", "boom"} {
+		_, err := parseSequence(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestSequenceCountersCap(t *testing.T) {
+	defer func(max int) { maxSequenceCounters = max }(maxSequenceCounters)
+	maxSequenceCounters = 3
+	tracker := newSequenceTracker()
+
+	for _, key := range []string{"a", "b", "c", "a", "d"} {
+		tracker.next("503,200", key)
+	}
+
+	keys := []string{}
+	for _, counter := range tracker.list() {
+		keys = append(keys, counter.Key)
+	}
+	// b is the least recently used key
+	assert.Equal(t, []string{"a", "c", "d"}, keys)
+	assert.Equal(t, 2, tracker.next("503,200", "a"))
+	assert.Equal(t, 0, tracker.next("503,200", "b"))
+
+	// c was dropped for b, then a reset key frees its room
+	assert.Equal(t, 1, tracker.reset("a"))
+	assert.Equal(t, 0, tracker.next("503,200", "e"))
+	assert.Len(t, tracker.list(), 3)
+	assert.Equal(t, 1, tracker.next("503,200", "d"))
+}
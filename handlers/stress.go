@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/efbar/minimal-service/config"
+	"github.com/efbar/minimal-service/logging"
+	"github.com/efbar/minimal-service/stress"
+)
+
+// stressLimits ... env with the limit of every kind of stressor
+var stressLimits = map[string]string{
+	"cpu":        "STRESS_MAX_CORES",
+	"memory":     "STRESS_MAX_MEMORY_MIB",
+	"goroutines": "STRESS_MAX_GOROUTINES",
+	"disk":       "STRESS_MAX_DISK_MIB",
+}
+
+// Stress ...
+type Stress struct {
+	log      logging.Logger
+	envs     *config.Store
+	registry *stress.Registry
+}
+
+// HandlerStress ...
+func HandlerStress(l logging.Logger, envs *config.Store, registry *stress.Registry) *Stress {
+	return &Stress{
+		log:      l,
+		envs:     envs,
+		registry: registry,
+	}
+}
+
+// ServeHTTP ... GET lists the running stressors, POST on /stress/<kind> starts one,
+// DELETE on /stress/<id> cancels one and on /stress cancels all of them
+func (h *Stress) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	h.log.Debug(h.envs.Get("DEBUG"), r.Method, "on", r.URL.String(), "from", r.RemoteAddr)
+
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/stress"), "/")
+
+	switch {
+	case r.Method == http.MethodGet && len(name) == 0:
+		h.encode(rw, http.StatusOK, h.registry.List())
+	case r.Method == http.MethodPost && len(name) != 0:
+		h.start(rw, r, name)
+	case r.Method == http.MethodDelete && len(name) == 0:
+		n := h.registry.CancelAll()
+		h.log.Info("Stress:", strconv.Itoa(n), "stressors cancelled by", r.RemoteAddr)
+		h.encode(rw, http.StatusOK, h.registry.List())
+	case r.Method == http.MethodDelete:
+		if !h.registry.Cancel(name) {
+			ErrorJSON(rw, "No stressor "+name, http.StatusNotFound)
+			return
+		}
+		h.log.Info("Stress:", name, "cancelled by", r.RemoteAddr)
+		rw.WriteHeader(http.StatusNoContent)
+	default:
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// start ... start a stressor of kind, with the amount and duration in the query
+func (h *Stress) start(rw http.ResponseWriter, r *http.Request, kind string) {
+	limitEnv, ok := stressLimits[kind]
+	if !ok {
+		ErrorJSON(rw, "Unknown stressor "+kind, http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	amount, err := strconv.Atoi(query.Get("amount"))
+	if err != nil {
+		ErrorJSON(rw, "amount must be a number of "+stress.Kinds[kind], http.StatusBadRequest)
+		return
+	}
+
+	maxDuration, _ := strconv.Atoi(h.envs.Get("STRESS_MAX_DURATION"))
+	duration := time.Duration(maxDuration) * time.Second
+	if value := query.Get("duration"); len(value) != 0 {
+		duration, err = time.ParseDuration(value)
+		if err != nil || duration <= 0 {
+			ErrorJSON(rw, "duration must be positive, like 30s", http.StatusBadRequest)
+			return
+		}
+	}
+	if duration > time.Duration(maxDuration)*time.Second {
+		ErrorJSON(rw, "duration over the limit of "+strconv.Itoa(maxDuration)+"s", http.StatusBadRequest)
+		return
+	}
+
+	limit, _ := strconv.Atoi(h.envs.Get(limitEnv))
+	s, err := h.registry.Start(kind, amount, duration, limit)
+	if err != nil {
+		ErrorJSON(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.log.Info("Stress:", s.ID, "started by", r.RemoteAddr)
+
+	h.encode(rw, http.StatusCreated, s)
+}
+
+func (h *Stress) encode(rw http.ResponseWriter, code int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	if err := json.NewEncoder(rw).Encode(v); err != nil {
+		h.log.Error("error encoding json", err.Error())
+	}
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/efbar/minimal-service/config"
+	"github.com/efbar/minimal-service/logging"
+	"github.com/efbar/minimal-service/stress"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupStressTest(t *testing.T) *Stress {
+	l := log.New(os.Stdout,
+		"Test Logger: ",
+		log.Ldate|log.Ltime)
+	logger := &logging.Logger{
+		Logger: l,
+	}
+	envs := config.NewStore(map[string]string{
+		"STRESS_MAX_CORES":      "1",
+		"STRESS_MAX_MEMORY_MIB": "4",
+		"STRESS_MAX_GOROUTINES": "100",
+		"STRESS_MAX_DISK_MIB":   "2",
+		"STRESS_MAX_DURATION":   "10",
+	})
+
+	return HandlerStress(*logger, envs, stress.NewRegistry(*logger))
+}
+
+func TestStressStart(t *testing.T) {
+	tt := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		kind   string
+	}{
+		{
+			name:   "cpu",
+			method: "POST",
+			path:   "/stress/cpu?amount=1&duration=5s",
+			status: http.StatusCreated,
+			kind:   "cpu",
+		},
+		{
+			name:   "memory",
+			method: "POST",
+			path:   "/stress/memory?amount=2",
+			status: http.StatusCreated,
+			kind:   "memory",
+		},
+		{
+			name:   "goroutines",
+			method: "POST",
+			path:   "/stress/goroutines?amount=50&duration=1s",
+			status: http.StatusCreated,
+			kind:   "goroutines",
+		},
+		{
+			name:   "disk",
+			method: "POST",
+			path:   "/stress/disk?amount=1",
+			status: http.StatusCreated,
+			kind:   "disk",
+		},
+		{
+			name:   "over the amount limit",
+			method: "POST",
+			path:   "/stress/memory?amount=5",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "over the duration limit",
+			method: "POST",
+			path:   "/stress/cpu?amount=1&duration=1h",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "without amount",
+			method: "POST",
+			path:   "/stress/disk",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "unknown stressor",
+			method: "POST",
+			path:   "/stress/network?amount=1",
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "PUT request",
+			method: "PUT",
+			path:   "/stress/cpu?amount=1",
+			status: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tr := range tt {
+		handler := setupStressTest(t)
+
+		t.Run(tr.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(tr.method, tr.path, nil))
+
+			assert.Equal(t, tr.status, rr.Code)
+			if tr.status != http.StatusCreated {
+				return
+			}
+			s := stress.Stressor{}
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &s))
+			assert.Equal(t, tr.kind, s.Kind)
+			assert.Len(t, handler.registry.List(), 1)
+
+			handler.registry.CancelAll()
+			assert.Eventually(t, func() bool { return len(handler.registry.List()) == 0 }, 5*time.Second, 10*time.Millisecond)
+		})
+	}
+}
+
+func TestStressListAndCancel(t *testing.T) {
+	handler := setupStressTest(t)
+
+	for _, path := range []string{"/stress/goroutines?amount=60", "/stress/goroutines?amount=40", "/stress/goroutines?amount=1"} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("POST", path, nil))
+		if path == "/stress/goroutines?amount=1" {
+			// the limit is for all the stressors of the same kind
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+		} else {
+			assert.Equal(t, http.StatusCreated, rr.Code)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/stress", nil))
+	list := []stress.Stressor{}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &list))
+	if assert.Len(t, list, 2) {
+		assert.Equal(t, "goroutines-1", list[0].ID)
+		assert.Equal(t, 60, list[0].Amount)
+		assert.Equal(t, "10s", list[0].Remaining)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("DELETE", "/stress/goroutines-1", nil))
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Eventually(t, func() bool { return len(handler.registry.List()) == 1 }, time.Second, 10*time.Millisecond)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("DELETE", "/stress/goroutines-1", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("DELETE", "/stress", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Eventually(t, func() bool { return len(handler.registry.List()) == 0 }, time.Second, 10*time.Millisecond)
+}
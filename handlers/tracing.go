@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/efbar/minimal-service/config"
+	"github.com/efbar/minimal-service/helpers"
+	"github.com/efbar/minimal-service/logging"
+	"github.com/efbar/minimal-service/tracer"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracingReady ... set up the trace provider exporting to JAEGER_URL, replaced in tests
+var tracingReady = func(envs *config.Store) error {
+	host, _ := helpers.GetHostname()
+
+	return tracer.Init(envs.Get("JAEGER_URL"), "minimal-service", []label.KeyValue{
+		label.String("Exporter", "opentracing-jaeger-plugin"),
+		label.String("Hostname", host),
+	})
+}
+
+// propagator ... the TRACE_PROPAGATORS formats, only W3C trace context if not set or not valid
+func propagator(l logging.Logger, envs *config.Store) propagation.TextMapPropagator {
+	names := envs.Get("TRACE_PROPAGATORS")
+	if len(names) == 0 {
+		return propagation.TraceContext{}
+	}
+	p, err := tracer.Propagator(names)
+	if err != nil {
+		l.Error("TRACE_PROPAGATORS,", err.Error())
+		return propagation.TraceContext{}
+	}
+
+	return p
+}
+
+// TraceInfo ... trace context of the request, the span ids are
+// the one of the service and the one of the caller
+type TraceInfo struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId,omitempty"`
+	ParentSpanID string `json:"parentSpanId,omitempty"`
+}
+
+// startServerSpan ... start the span of an inbound request, child of the trace context it carries,
+// the span is a not recording one if TRACING is not enabled but the trace context is still extracted
+func startServerSpan(r *http.Request, l logging.Logger, envs *config.Store) (*http.Request, trace.Span) {
+	ctx := propagator(l, envs).Extract(r.Context(), tracer.HeaderCarrier(r.Header))
+	r = r.WithContext(ctx)
+	if envs.Get("TRACING") != "1" {
+		return r, trace.SpanFromContext(context.Background())
+	}
+	if err := tracingReady(envs); err != nil {
+		l.Error("TRACING, ", err.Error())
+		return r, trace.SpanFromContext(context.Background())
+	}
+
+	ctx, span := otel.Tracer("minimal-service").Start(ctx, r.Method+" "+r.URL.Path,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			label.String("http.method", r.Method),
+			label.String("http.target", r.URL.RequestURI()),
+		),
+	)
+
+	return r.WithContext(ctx), span
+}
+
+// traceFrom ... trace context of the request, nil if it has none
+func traceFrom(r *http.Request) *TraceInfo {
+	own := trace.SpanContextFromContext(r.Context())
+	caller := trace.RemoteSpanContextFromContext(r.Context())
+
+	switch {
+	case own.IsValid():
+		info := &TraceInfo{TraceID: own.TraceID.String(), SpanID: own.SpanID.String()}
+		if caller.IsValid() && caller.TraceID == own.TraceID {
+			info.ParentSpanID = caller.SpanID.String()
+		}
+		return info
+	case caller.IsValid():
+		return &TraceInfo{TraceID: caller.TraceID.String(), ParentSpanID: caller.SpanID.String()}
+	}
+
+	return nil
+}
+
+// remoteSpan ... not recording span with the trace context of the caller
+type remoteSpan struct {
+	trace.Span
+	sc trace.SpanContext
+}
+
+func (s remoteSpan) SpanContext() trace.SpanContext {
+	return s.sc
+}
+
+// startClientSpan ... start the span of a request to an upstream, child of the span in ctx,
+// and inject its trace context in the request headers. Without a recording span the trace
+// context of the caller is injected as it is, so that the trace isn't broken.
+func startClientSpan(ctx context.Context, req *http.Request, l logging.Logger, envs *config.Store) (context.Context, trace.Span) {
+	parent := trace.SpanFromContext(ctx)
+	if !parent.IsRecording() {
+		if caller := trace.RemoteSpanContextFromContext(ctx); caller.IsValid() {
+			remote := trace.ContextWithSpan(ctx, remoteSpan{Span: parent, sc: caller})
+			propagator(l, envs).Inject(remote, tracer.HeaderCarrier(req.Header))
+		}
+		return ctx, parent
+	}
+
+	ctx, span := parent.Tracer().Start(ctx, req.Method+" "+req.URL.Host,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			label.String("http.method", req.Method),
+			label.String("http.url", req.URL.String()),
+		),
+	)
+	propagator(l, envs).Inject(ctx, tracer.HeaderCarrier(req.Header))
+
+	return ctx, span
+}
+
+func (h *Data) execTracing(ctx context.Context, code int, message string, headers map[string]string) {
+	execTracing(ctx, h.envs, code, message, headers)
+}
+
+// execTracing ... annotate the span of a request served with code, if TRACING is enabled
+func execTracing(ctx context.Context, envs *config.Store, code int, message string, headers map[string]string) {
+	if envs.Get("TRACING") != "1" {
+		return
+	}
+
+	tags := []label.KeyValue{}
+	for key, value := range headers {
+		tags = append(tags, label.String(key, value))
+	}
+	tracer.Annotate(trace.SpanFromContext(ctx), code, message, tags)
+}
+
+func validPropagators(value string) error {
+	_, err := tracer.Propagator(value)
+	return err
+}
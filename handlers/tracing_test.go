@@ -0,0 +1,426 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/efbar/minimal-service/config"
+	"github.com/efbar/minimal-service/tracer"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/oteltest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans ... trace with a recording provider, without Jaeger
+func recordSpans(t *testing.T) *oteltest.StandardSpanRecorder {
+	sr := &oteltest.StandardSpanRecorder{}
+	provider, ready := otel.GetTracerProvider(), tracingReady
+	otel.SetTracerProvider(oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr)))
+	tracingReady = func(*config.Store) error { return nil }
+	t.Cleanup(func() {
+		otel.SetTracerProvider(provider)
+		tracingReady = ready
+	})
+
+	return sr
+}
+
+// tracingServer ... a minimal-service with TRACING on
+func tracingServer(t *testing.T, propagators string) *httptest.Server {
+	handler := setupReqHTTPTest(t)
+	handler.envs.Set("TRACING", "1")
+	handler.envs.Set("TRACE_PROPAGATORS", propagators)
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestBounceTraceContext(t *testing.T) {
+	sr := recordSpans(t)
+	var received http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		received = r.Header
+	}))
+	defer upstream.Close()
+
+	tt := []struct {
+		name    string
+		tracing string
+		spans   int
+	}{
+		{
+			name:    "tracing enabled",
+			tracing: "1",
+			spans:   2,
+		},
+		{
+			name:    "tracing disabled",
+			tracing: "0",
+		},
+	}
+
+	for _, tr := range tt {
+		handler := setupReqHTTPTest(t)
+		handler.envs.Set("TRACING", tr.tracing)
+
+		t.Run(tr.name, func(t *testing.T) {
+			received = nil
+			before := len(sr.Completed())
+			body, _ := json.Marshal(&JSONPost{Rebound: "true", Endpoint: upstream.URL})
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, httptest.NewRequest("POST", "/bounce", bytes.NewReader(body)))
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+			if !assert.NotNil(t, received) {
+				return
+			}
+			spans := sr.Completed()[before:]
+			if !assert.Len(t, spans, tr.spans) {
+				return
+			}
+			if tr.spans == 0 {
+				assert.Empty(t, received.Get("traceparent"))
+				return
+			}
+
+			// the client span ends first
+			client, server := spans[0], spans[1]
+			assert.Equal(t, trace.SpanKindClient, client.SpanKind())
+			assert.Equal(t, trace.SpanKindServer, server.SpanKind())
+			assert.Equal(t, server.SpanContext().TraceID, client.SpanContext().TraceID)
+			assert.Equal(t, server.SpanContext().SpanID, client.ParentSpanID())
+			assert.Equal(t, "POST /bounce", server.Name())
+			assert.Equal(t, int64(200), client.Attributes()["http.status_code"].AsInt64())
+
+			sc := client.SpanContext()
+			assert.Equal(t, "00-"+sc.TraceID.String()+"-"+sc.SpanID.String()+"-00", received.Get("traceparent"))
+		})
+	}
+}
+
+func TestBounceTraceChain(t *testing.T) {
+	sr := recordSpans(t)
+	first, second := tracingServer(t, "tracecontext"), tracingServer(t, "tracecontext")
+
+	body, _ := json.Marshal(&JSONPost{Rebound: "true", Endpoint: second.URL})
+	resp, err := http.Post(first.URL+"/bounce", "application/json", bytes.NewReader(body))
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	spans := map[trace.SpanKind][]*oteltest.Span{}
+	for _, span := range sr.Completed() {
+		spans[span.SpanKind()] = append(spans[span.SpanKind()], span)
+	}
+	if !assert.Len(t, spans[trace.SpanKindServer], 2) || !assert.Len(t, spans[trace.SpanKindClient], 1) {
+		return
+	}
+	// the upstream ends its span first
+	upstream, inbound := spans[trace.SpanKindServer][0], spans[trace.SpanKindServer][1]
+	client := spans[trace.SpanKindClient][0]
+
+	assert.Equal(t, inbound.SpanContext().TraceID, client.SpanContext().TraceID)
+	assert.Equal(t, inbound.SpanContext().TraceID, upstream.SpanContext().TraceID)
+	assert.Equal(t, inbound.SpanContext().SpanID, client.ParentSpanID())
+	assert.Equal(t, client.SpanContext().SpanID, upstream.ParentSpanID())
+	assert.Equal(t, "POST /bounce", inbound.Name())
+	assert.Equal(t, int64(200), client.Attributes()["http.status_code"].AsInt64())
+}
+
+func TestBounceTraceHeaders(t *testing.T) {
+	recordSpans(t)
+	var received http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		received = r.Header
+	}))
+	defer upstream.Close()
+
+	tt := []struct {
+		name        string
+		propagators string
+		inbound     map[string]string
+		headers     []string
+		absent      []string
+		traceID     string
+	}{
+		{
+			name:        "W3C trace context by default",
+			propagators: "tracecontext",
+			inbound:     map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+			headers:     []string{"traceparent"},
+			absent:      []string{"b3", "uber-trace-id"},
+			traceID:     "4bf92f3577b34da6a3ce929d0e0e4736",
+		},
+		{
+			name:        "all the formats",
+			propagators: "tracecontext,b3,b3multi,jaeger",
+			headers:     []string{"traceparent", "b3", "X-B3-TraceId", "X-B3-SpanId", "X-B3-Sampled", "uber-trace-id"},
+		},
+		{
+			name:        "B3 in, B3 out",
+			propagators: "b3",
+			inbound:     map[string]string{"b3": "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1"},
+			headers:     []string{"b3"},
+			absent:      []string{"traceparent"},
+			traceID:     "80f198ee56343ba864fe8b2a57d3eff7",
+		},
+		{
+			name:        "Jaeger in, Jaeger out",
+			propagators: "jaeger",
+			inbound:     map[string]string{"uber-trace-id": "a3ce929d0e0e4736:00f067aa0ba902b7:0:1"},
+			headers:     []string{"uber-trace-id"},
+			traceID:     "0000000000000000a3ce929d0e0e4736",
+		},
+	}
+
+	for _, tr := range tt {
+		srv := tracingServer(t, tr.propagators)
+
+		t.Run(tr.name, func(t *testing.T) {
+			received = nil
+			body, _ := json.Marshal(&JSONPost{Rebound: "true", Endpoint: upstream.URL})
+			req, _ := http.NewRequest("POST", srv.URL+"/bounce", bytes.NewReader(body))
+			for key, value := range tr.inbound {
+				req.Header.Set(key, value)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if !assert.NoError(t, err) {
+				return
+			}
+			resp.Body.Close()
+
+			if !assert.NotNil(t, received) {
+				return
+			}
+			for _, key := range tr.headers {
+				assert.NotEmpty(t, received.Get(key), key)
+			}
+			for _, key := range tr.absent {
+				assert.Empty(t, received.Get(key), key)
+			}
+			if tr.traceID != "" {
+				p, _ := tracer.Propagator(tr.propagators)
+				sc := trace.RemoteSpanContextFromContext(p.Extract(context.Background(), tracer.HeaderCarrier(received)))
+				assert.Equal(t, tr.traceID, sc.TraceID.String())
+			}
+		})
+	}
+}
+
+func TestBounceTraceWithoutTracing(t *testing.T) {
+	var received http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		received = r.Header
+	}))
+	defer upstream.Close()
+
+	tt := []struct {
+		name        string
+		propagators string
+		inbound     map[string]string
+		outbound    map[string]string
+	}{
+		{
+			name:        "W3C trace context",
+			propagators: "tracecontext",
+			inbound: map[string]string{
+				"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+				"tracestate":  "vendor=value",
+			},
+			outbound: map[string]string{
+				"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+				"tracestate":  "vendor=value",
+			},
+		},
+		{
+			name:        "B3 in, W3C and B3 out",
+			propagators: "tracecontext,b3",
+			inbound:     map[string]string{"b3": "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1"},
+			outbound: map[string]string{
+				"traceparent": "00-80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-01",
+				"b3":          "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1",
+			},
+		},
+		{
+			name:        "no trace context",
+			propagators: "tracecontext",
+			outbound:    map[string]string{"traceparent": ""},
+		},
+	}
+
+	for _, tr := range tt {
+		handler := setupReqHTTPTest(t)
+		handler.envs.Set("TRACING", "0")
+		handler.envs.Set("TRACE_PROPAGATORS", tr.propagators)
+
+		t.Run(tr.name, func(t *testing.T) {
+			received = nil
+			body, _ := json.Marshal(&JSONPost{Rebound: "true", Endpoint: upstream.URL})
+			req := httptest.NewRequest("POST", "/bounce", bytes.NewReader(body))
+			for key, value := range tr.inbound {
+				req.Header.Set(key, value)
+			}
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+			if !assert.NotNil(t, received) {
+				return
+			}
+			for key, value := range tr.outbound {
+				assert.Equal(t, value, received.Get(key), key)
+			}
+		})
+	}
+}
+
+func TestPropagatorExtract(t *testing.T) {
+	tt := []struct {
+		name    string
+		headers map[string]string
+		traceID string
+		spanID  string
+		sampled bool
+	}{
+		{
+			name:    "b3 single header",
+			headers: map[string]string{"b3": "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1-05e3ac9a4f6e3b90"},
+			traceID: "80f198ee56343ba864fe8b2a57d3eff7",
+			spanID:  "e457b5a2e4d86bd1",
+			sampled: true,
+		},
+		{
+			name: "b3 multiple headers with 64 bit trace id",
+			headers: map[string]string{
+				"X-B3-TraceId": "64fe8b2a57d3eff7",
+				"X-B3-SpanId":  "e457b5a2e4d86bd1",
+				"X-B3-Sampled": "0",
+			},
+			traceID: "000000000000000064fe8b2a57d3eff7",
+			spanID:  "e457b5a2e4d86bd1",
+		},
+		{
+			name:    "uber-trace-id",
+			headers: map[string]string{"uber-trace-id": "4bf92f3577b34da6a3ce929d0e0e4736:f067aa0ba902b7:0:3"},
+			traceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+			spanID:  "00f067aa0ba902b7",
+			sampled: true,
+		},
+		{
+			name:    "b3 sampling only",
+			headers: map[string]string{"b3": "1"},
+		},
+		{
+			name:    "not valid uber-trace-id",
+			headers: map[string]string{"uber-trace-id": "xyz:1:0:1"},
+		},
+	}
+
+	p, err := tracer.Propagator("b3,jaeger")
+	assert.NoError(t, err)
+	for _, tr := range tt {
+		t.Run(tr.name, func(t *testing.T) {
+			header := http.Header{}
+			for key, value := range tr.headers {
+				header.Set(key, value)
+			}
+
+			sc := trace.RemoteSpanContextFromContext(p.Extract(context.Background(), tracer.HeaderCarrier(header)))
+
+			if tr.traceID == "" {
+				assert.False(t, sc.IsValid())
+				return
+			}
+			assert.Equal(t, tr.traceID, sc.TraceID.String())
+			assert.Equal(t, tr.spanID, sc.SpanID.String())
+			assert.Equal(t, tr.sampled, sc.IsSampled())
+		})
+	}
+
+	_, err = tracer.Propagator("tracecontext,zipkin")
+	assert.Error(t, err)
+}
+
+func TestTraceEcho(t *testing.T) {
+	recordSpans(t)
+
+	tt := []struct {
+		name        string
+		tracing     string
+		propagators string
+		headers     map[string]string
+		trace       *TraceInfo
+		ownSpan     bool
+	}{
+		{
+			name:        "no trace context",
+			tracing:     "0",
+			propagators: "tracecontext",
+		},
+		{
+			name:        "W3C context echoed without tracing",
+			tracing:     "0",
+			propagators: "tracecontext,b3",
+			headers:     map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+			trace:       &TraceInfo{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", ParentSpanID: "00f067aa0ba902b7"},
+		},
+		{
+			name:        "B3 multi context continued by the service span",
+			tracing:     "1",
+			propagators: "b3multi",
+			headers: map[string]string{
+				"X-B3-TraceId": "80f198ee56343ba864fe8b2a57d3eff7",
+				"X-B3-SpanId":  "e457b5a2e4d86bd1",
+				"X-B3-Sampled": "1",
+			},
+			trace:   &TraceInfo{TraceID: "80f198ee56343ba864fe8b2a57d3eff7", ParentSpanID: "e457b5a2e4d86bd1"},
+			ownSpan: true,
+		},
+		{
+			name:        "format not configured is ignored",
+			tracing:     "0",
+			propagators: "tracecontext",
+			headers:     map[string]string{"uber-trace-id": "a3ce929d0e0e4736:00f067aa0ba902b7:0:1"},
+		},
+	}
+
+	for _, tr := range tt {
+		handler := setupReqHTTPTest(t)
+		handler.envs.Set("TRACING", tr.tracing)
+		handler.envs.Set("TRACE_PROPAGATORS", tr.propagators)
+
+		t.Run(tr.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Content-type", "application/json")
+			for key, value := range tr.headers {
+				req.Header.Set(key, value)
+			}
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			js := &JSONResponse{}
+			assert.NoError(t, json.NewDecoder(rr.Body).Decode(js))
+			if tr.trace == nil {
+				assert.Nil(t, js.Trace)
+				return
+			}
+			if !assert.NotNil(t, js.Trace) {
+				return
+			}
+			assert.Equal(t, tr.trace.TraceID, js.Trace.TraceID)
+			assert.Equal(t, tr.trace.ParentSpanID, js.Trace.ParentSpanID)
+			assert.Equal(t, tr.ownSpan, js.Trace.SpanID != "")
+		})
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/efbar/minimal-service/logging"
@@ -31,31 +34,92 @@ func GetHostname() (string, error) {
 	return host, err
 }
 
+// valuableEnv ... envs read at start
+var valuableEnv = []string{
+	"HTTPS",
+	"SERVICE_PORT",
+	"DELAY_MAX",
+	"DELAY_MS",
+	"DELAY_PERCENT",
+	"DELAY_DISTRIBUTION",
+	"DELAY_MIN_MS",
+	"DELAY_MAX_MS",
+	"DELAY_MEAN_MS",
+	"DELAY_STDDEV_MS",
+	"DELAY_P50_MS",
+	"DELAY_P90_MS",
+	"DELAY_P99_MS",
+	"TRACING",
+	"JAEGER_URL",
+	"TRACE_PROPAGATORS",
+	"DISCARD_QUOTA",
+	"REJECT",
+	"REJECT_STATUS",
+	"REJECT_RETRY_AFTER",
+	"DISCARD_MODE",
+	"DRIP_RATE",
+	"HANG_MAX",
+	"FAULT_HEADERS",
+	"FAULT_RULES",
+	"FAULT_SEED",
+	"FAULT_HASH_HEADER",
+	"FAULT_SEQUENCE",
+	"FAULT_SEQUENCE_KEY",
+	"FAULT_SEQUENCE_LOOP",
+	"HEALTH_FAIL",
+	"HEALTH_FAIL_AFTER",
+	"HEALTH_FLAP_INTERVAL",
+	"HEALTH_FAIL_PERCENT",
+	"HEALTH_DELAY_MS",
+	"LIVE_FAIL",
+	"READY_FAIL",
+	"READY_DEPENDENCIES",
+	"STARTUP_DELAY",
+	"BOUNCE_TARGETS",
+	"BOUNCE_MAX_BODY",
+	"BOUNCE_TIMEOUT_MS",
+	"BOUNCE_OVERALL_TIMEOUT_MS",
+	"BOUNCE_RETRIES",
+	"BOUNCE_BACKOFF_MS",
+	"BOUNCE_BACKOFF_MAX_MS",
+	"BOUNCE_RETRY_ON",
+	"BREAKER_FAILURES",
+	"BREAKER_OPEN_SECONDS",
+	"CERT_WARN_DAYS",
+	"DRAIN_PERIOD",
+	"SHUTDOWN_TIMEOUT",
+	"STRESS_MAX_CORES",
+	"STRESS_MAX_MEMORY_MIB",
+	"STRESS_MAX_GOROUTINES",
+	"STRESS_MAX_DISK_MIB",
+	"STRESS_MAX_DURATION",
+	"SCENARIO_FILE",
+	"ADMIN_TOKEN",
+	"ADMIN_CLIENT_CERTS",
+	"ADMIN_CIDRS",
+	"DEBUG",
+	"CONNECT",
+	"CONNECT_TLS",
+	"CONSUL_AGENT",
+	"CONSUL_HTTP_TOKEN",
+	"CONSUL_CACERT",
+	"HOST_IP",
+	"POD_IP",
+	"POD_NAME",
+	"POD_NAMESPACE",
+}
+
+// valuablePrefix ... envs read at start by their prefix
+var valuablePrefix = []string{
+	"REJECT_BODY_",
+}
+
 // ReadEnv ... collect important envs and set some defaults if needed
 func ReadEnv() map[string]string {
-	valuableEnv := []string{
-		"HTTPS",
-		"SERVICE_PORT",
-		"DELAY_MAX",
-		"TRACING",
-		"JAEGER_URL",
-		"DISCARD_QUOTA",
-		"REJECT",
-		"DEBUG",
-		"CONNECT",
-		"CONSUL_AGENT",
-		"CONSUL_HTTP_TOKEN",
-		"CONSUL_CACERT",
-		"HOST_IP",
-		"POD_IP",
-		"POD_NAME",
-		"POD_NAMESPACE",
-	}
-
 	pair := map[string]string{}
 	for _, elem := range os.Environ() {
 		keyval := strings.SplitN(elem, "=", 2)
-		if contains(valuableEnv, keyval[0]) {
+		if KnownEnv(keyval[0]) {
 			pair[keyval[0]] = keyval[1]
 		}
 	}
@@ -70,18 +134,99 @@ func ReadEnv() map[string]string {
 	if len(pair["DELAY_MAX"]) == 0 {
 		pair["DELAY_MAX"] = "0"
 	}
+	if len(pair["DELAY_MS"]) == 0 {
+		pair["DELAY_MS"] = "0"
+	}
+	if len(pair["DELAY_PERCENT"]) == 0 {
+		pair["DELAY_PERCENT"] = "100"
+	}
 	if len(pair["TRACING"]) == 0 {
 		pair["TRACING"] = "0"
 	}
 	if len(pair["JAEGER_URL"]) == 0 {
 		pair["JAEGER_URL"] = "http://localhost:14268/api/traces"
 	}
+	if len(pair["TRACE_PROPAGATORS"]) == 0 {
+		pair["TRACE_PROPAGATORS"] = "tracecontext"
+	}
 	if len(pair["DISCARD_QUOTA"]) == 0 {
 		pair["DISCARD_QUOTA"] = "0"
 	}
 	if len(pair["REJECT"]) == 0 {
 		pair["REJECT"] = "0"
 	}
+	if len(pair["DISCARD_MODE"]) == 0 {
+		pair["DISCARD_MODE"] = "empty"
+	}
+	if len(pair["DRIP_RATE"]) == 0 {
+		pair["DRIP_RATE"] = "1024"
+	}
+	if len(pair["HANG_MAX"]) == 0 {
+		pair["HANG_MAX"] = "0"
+	}
+	if len(pair["REJECT_STATUS"]) == 0 {
+		pair["REJECT_STATUS"] = "500"
+	}
+	if len(pair["FAULT_HEADERS"]) == 0 {
+		pair["FAULT_HEADERS"] = "1"
+	}
+	if len(pair["HEALTH_FAIL"]) == 0 {
+		pair["HEALTH_FAIL"] = "0"
+	}
+	if len(pair["STRESS_MAX_CORES"]) == 0 {
+		pair["STRESS_MAX_CORES"] = strconv.Itoa(runtime.NumCPU())
+	}
+	if len(pair["STRESS_MAX_MEMORY_MIB"]) == 0 {
+		pair["STRESS_MAX_MEMORY_MIB"] = "512"
+	}
+	if len(pair["STRESS_MAX_GOROUTINES"]) == 0 {
+		pair["STRESS_MAX_GOROUTINES"] = "10000"
+	}
+	if len(pair["STRESS_MAX_DISK_MIB"]) == 0 {
+		pair["STRESS_MAX_DISK_MIB"] = "1024"
+	}
+	if len(pair["STRESS_MAX_DURATION"]) == 0 {
+		pair["STRESS_MAX_DURATION"] = "300"
+	}
+	if len(pair["DRAIN_PERIOD"]) == 0 {
+		pair["DRAIN_PERIOD"] = "0"
+	}
+	if len(pair["SHUTDOWN_TIMEOUT"]) == 0 {
+		pair["SHUTDOWN_TIMEOUT"] = "3"
+	}
+	if len(pair["BOUNCE_MAX_BODY"]) == 0 {
+		pair["BOUNCE_MAX_BODY"] = "4096"
+	}
+	if len(pair["BOUNCE_TIMEOUT_MS"]) == 0 {
+		pair["BOUNCE_TIMEOUT_MS"] = "0"
+	}
+	if len(pair["BOUNCE_OVERALL_TIMEOUT_MS"]) == 0 {
+		pair["BOUNCE_OVERALL_TIMEOUT_MS"] = "0"
+	}
+	if len(pair["BOUNCE_RETRIES"]) == 0 {
+		pair["BOUNCE_RETRIES"] = "0"
+	}
+	if len(pair["BOUNCE_BACKOFF_MS"]) == 0 {
+		pair["BOUNCE_BACKOFF_MS"] = "100"
+	}
+	if len(pair["BOUNCE_BACKOFF_MAX_MS"]) == 0 {
+		pair["BOUNCE_BACKOFF_MAX_MS"] = "2000"
+	}
+	if len(pair["BOUNCE_RETRY_ON"]) == 0 {
+		pair["BOUNCE_RETRY_ON"] = "502,503,504"
+	}
+	if len(pair["BREAKER_FAILURES"]) == 0 {
+		pair["BREAKER_FAILURES"] = "0"
+	}
+	if len(pair["BREAKER_OPEN_SECONDS"]) == 0 {
+		pair["BREAKER_OPEN_SECONDS"] = "30"
+	}
+	if len(pair["CERT_WARN_DAYS"]) == 0 {
+		pair["CERT_WARN_DAYS"] = "30"
+	}
+	if len(pair["STARTUP_DELAY"]) == 0 {
+		pair["STARTUP_DELAY"] = "0"
+	}
 	if len(pair["DEBUG"]) == 0 {
 		pair["DEBUG"] = "0"
 	}
@@ -91,10 +236,18 @@ func ReadEnv() map[string]string {
 	if len(pair["CONNECT"]) == 0 {
 		pair["CONNECT"] = "0"
 	}
+	if len(pair["CONNECT_TLS"]) == 0 {
+		pair["CONNECT_TLS"] = "0"
+	}
 
 	return pair
 }
 
+// KnownEnv ... true if the env is one of the envs read at start
+func KnownEnv(key string) bool {
+	return contains(valuableEnv, key) || hasPrefix(valuablePrefix, key)
+}
+
 func contains(listS []string, s string) bool {
 	for _, value := range listS {
 		if value == s {
@@ -105,12 +258,51 @@ func contains(listS []string, s string) bool {
 	return false
 }
 
+func hasPrefix(listP []string, s string) bool {
+	for _, prefix := range listP {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // RandBool ... random true/false generator based on quota percentage
-func RandBool(i int, l *logging.Logger) bool {
+func RandBool(rng *rand.Rand, i int, l *logging.Logger) bool {
 	if i > 100 || i < 0 {
 		i = 0
 	}
 	quota := float32(i) / float32(100)
 
-	return rand.Float32() < quota
+	return rng.Float32() < quota
+}
+
+// NewRand ... random generator from seed, safe for concurrent use
+func NewRand(seed int64) *rand.Rand {
+	return rand.New(&lockedSource{src: rand.NewSource(seed).(rand.Source64)})
+}
+
+// lockedSource ... math/rand source guarded by a mutex, like the global one
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source64
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Uint64()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
 }
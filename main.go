@@ -2,6 +2,9 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
@@ -12,13 +15,23 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/efbar/minimal-service/config"
 	"github.com/efbar/minimal-service/handlers"
 	"github.com/efbar/minimal-service/helpers"
 	"github.com/efbar/minimal-service/logging"
+	"github.com/efbar/minimal-service/scenario"
+	"github.com/efbar/minimal-service/stress"
+	"github.com/efbar/minimal-service/tracer"
 	consul "github.com/hashicorp/consul/api"
 	"github.com/hashicorp/consul/connect"
 )
 
+// serviceName ... name of the service in Consul, serviceID the id of this instance
+const (
+	serviceName = "minimal-service"
+	serviceID   = "_" + serviceName
+)
+
 func main() {
 
 	// create a logger object
@@ -40,11 +53,49 @@ func main() {
 	// set service port
 	port := envs["SERVICE_PORT"]
 
+	// lifecycle of the service, backing the probes
+	lifecycle := handlers.NewLifecycle()
+
+	// if consul connect enabled, connect to it,
+	// consul:// bounce endpoints are called through its Connect service
+	var client *consul.Client
+	var svc *connect.Service
+	var mesh *handlers.Mesh
+	if envs["CONNECT"] == "1" {
+		var err error
+		client, svc, err = connectToConsul(envs, logger)
+		lifecycle.SetRegistered(err == nil)
+		if svc != nil {
+			mesh = handlers.NewMesh(svc, client)
+		}
+	}
+
+	// envs read by the handlers, they can be changed at runtime,
+	// created after the Consul setup that can change the agent in use
+	store := config.NewStore(envs)
+
 	// create http requests handlers
-	anyReq := handlers.HandlerAnyHTTP(*logger, envs)
-	bounceReq := handlers.HandlerBounceHTTP(*logger, envs)
-	healthReq := handlers.HandlerHealth(*logger, envs)
-	crashReq := handlers.HandlerCrash(*logger, envs)
+	anyReq := handlers.HandlerAnyHTTP(*logger, store)
+	bounceReq := handlers.HandlerBounceHTTP(*logger, store, mesh)
+	healthReq := handlers.HandlerHealth(*logger, store)
+	crashReq := handlers.HandlerCrash(*logger, store)
+	adminReq := handlers.HandlerAdmin(*logger, store)
+	sequencesReq := handlers.HandlerSequences(*logger, store)
+	breakersReq := handlers.HandlerBreakers(*logger, store)
+	stressReq := handlers.HandlerStress(*logger, store, stress.NewRegistry(*logger))
+
+	// probes
+	livezReq := handlers.HandlerLiveness(*logger, store, lifecycle)
+	readyzReq := handlers.HandlerReadiness(*logger, store, lifecycle)
+	startupzReq := handlers.HandlerStartup(*logger, store, lifecycle)
+
+	// play the chaos scenario, if any
+	var player *scenario.Player
+	if len(envs["SCENARIO_FILE"]) != 0 {
+		player = loadScenario(envs["SCENARIO_FILE"], store, logger)
+		go player.Run(context.Background())
+	}
+	scenarioReq := handlers.HandlerScenario(*logger, store, player)
 
 	// create server mux
 	sm := http.NewServeMux()
@@ -53,29 +104,54 @@ func main() {
 	sm.Handle("/", anyReq)
 	sm.Handle("/bounce", bounceReq)
 	sm.Handle("/health", healthReq)
-	sm.Handle("/crash", crashReq)
+	sm.Handle("/livez", livezReq)
+	sm.Handle("/readyz", readyzReq)
+	sm.Handle("/startupz", startupzReq)
+
+	// destructive and admin paths, behind access control
+	if len(envs["ADMIN_TOKEN"]) == 0 && len(envs["ADMIN_CIDRS"]) == 0 && len(envs["ADMIN_CLIENT_CERTS"]) == 0 {
+		logger.Error("WARNING: none of ADMIN_TOKEN, ADMIN_CIDRS, ADMIN_CLIENT_CERTS is set, /crash, /stress and /admin/* are open to anyone")
+	}
+	sm.Handle("/crash", handlers.HandlerAuth(*logger, store, crashReq))
+	sm.Handle("/admin/faults", handlers.HandlerAuth(*logger, store, adminReq))
+	sm.Handle("/admin/scenario", handlers.HandlerAuth(*logger, store, scenarioReq))
+	sm.Handle("/admin/sequences", handlers.HandlerAuth(*logger, store, sequencesReq))
+	sm.Handle("/admin/breakers", handlers.HandlerAuth(*logger, store, breakersReq))
+	sm.Handle("/stress", handlers.HandlerAuth(*logger, store, stressReq))
+	sm.Handle("/stress/", handlers.HandlerAuth(*logger, store, stressReq))
 
 	// fill the new server config
 	s := http.Server{
 		Addr:         ":" + port,
-		Handler:      sm,
+		Handler:      lifecycle.Track(handlers.Freezable(sm)),
 		ErrorLog:     l,
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  120 * time.Second,
 	}
 
-	// if consul connect enabled, connect to it
-	var client *consul.Client
-	if envs["CONNECT"] == "1" {
-		client = connectToConsul(envs, logger)
+	// serve with the Connect certificates, rotated by the Connect service,
+	// only the Connect services allowed by the intentions can call
+	connectTLS := envs["CONNECT_TLS"] == "1" && svc != nil
+	if connectTLS {
+		s.TLSConfig = svc.ServerTLSConfig()
+		select {
+		case <-svc.ReadyWait():
+		case <-time.After(10 * time.Second):
+			logger.Error("Connect certificates not loaded yet, TLS handshakes will fail until they are")
+		}
+	} else if envs["HTTPS"] == "true" && len(envs["ADMIN_CLIENT_CERTS"]) != 0 {
+		// ask for client certificates, if some of them are allowed on admin paths
+		s.TLSConfig = clientAuthConfig("certs/ca.pem", logger)
 	}
 
 	// run the http server
 	go func() {
 		logger.Info("Starting server on port " + port)
 		var err error
-		if envs["HTTPS"] == "true" {
+		if connectTLS {
+			err = s.ListenAndServeTLS("", "")
+		} else if envs["HTTPS"] == "true" {
 			err = s.ListenAndServeTLS("certs/minimalservice.crt", "certs/minimalservice.key")
 		} else {
 			err = s.ListenAndServe()
@@ -94,22 +170,74 @@ func main() {
 	sig := <-c
 	logger.Info("Got signal:", sig.String())
 
+	// from now on the service is not ready
+	lifecycle.SetDraining(true)
+
 	// if we are here and consul connect is active, deregister the service from it
 	if envs["CONNECT"] == "1" {
-		if err := client.Agent().ServiceDeregister("minimal-service"); err != nil {
-			logger.Error(err.Error())
-		}
-		logger.Debug("Consul service deregistration")
+		deregisterService(client, logger)
+	}
+
+	// keep serving while load balancers and orchestrators notice the service is going away
+	if drain, _ := strconv.Atoi(store.Get("DRAIN_PERIOD")); drain > 0 {
+		logger.Info("Draining for", strconv.Itoa(drain), "seconds,", strconv.FormatInt(lifecycle.InFlight(), 10), "requests in flight")
+		time.Sleep(time.Duration(drain) * time.Second)
 	}
 
 	// gracefully shutdown if connections are active
-	// wait max 3 seconds before shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// wait max SHUTDOWN_TIMEOUT seconds before shutdown
+	timeout, _ := strconv.Atoi(store.Get("SHUTDOWN_TIMEOUT"))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
 	defer cancel()
-	s.Shutdown(ctx)
+	defer tracer.Flush()
+	if svc != nil {
+		defer svc.Close()
+	}
+	if err := s.Shutdown(ctx); err != nil {
+		logger.Error("Shutdown after", strconv.Itoa(timeout), "seconds,", strconv.FormatInt(lifecycle.InFlight(), 10), "requests in flight cut off")
+		return
+	}
+	logger.Info("Shutdown completed")
+}
+
+func clientAuthConfig(caFile string, logger *logging.Logger) *tls.Config {
+
+	ca, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		logger.Error("Error reading client CA,", err.Error())
+		os.Exit(1)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		logger.Error("No certificates found in", caFile)
+		os.Exit(1)
+	}
+
+	return &tls.Config{
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		ClientCAs:  pool,
+	}
 }
 
-func connectToConsul(envs map[string]string, logger *logging.Logger) *consul.Client {
+func loadScenario(file string, store *config.Store, logger *logging.Logger) *scenario.Player {
+
+	sc, err := scenario.Load(file)
+	if err != nil {
+		logger.Error("Error loading scenario,", err.Error())
+		os.Exit(1)
+	}
+	for _, phase := range sc.Phases {
+		if err := handlers.ValidateEnvs(phase.Envs); err != nil {
+			logger.Error("Error in scenario phase", phase.Name+",", err.Error())
+			os.Exit(1)
+		}
+	}
+	logger.Info("Loaded scenario", sc.Name, "from", file)
+
+	return scenario.NewPlayer(sc, store, *logger)
+}
+
+func connectToConsul(envs map[string]string, logger *logging.Logger) (*consul.Client, *connect.Service, error) {
 
 	// fill some vars if we are in kube
 	kubeNode := os.Getenv("HOST_IP")
@@ -142,14 +270,12 @@ func connectToConsul(envs map[string]string, logger *logging.Logger) *consul.Cli
 			MaxIdleConnsPerHost:   runtime.GOMAXPROCS(0) + 1,
 		},
 	})
-	svc, _ := connect.NewService("minimal-service", client)
-	defer svc.Close()
-
-	// set service details: serviceID, port, tags, address, meta tags
-	serviceID := "minimal-service"
-	port, _ := strconv.Atoi(envs["SERVICE_PORT"])
-	tags := []string{"microservice", "http"}
+	svc, err := connect.NewService(serviceName, client)
+	if err != nil {
+		logger.Error("Connect service,", err.Error())
+	}
 
+	// set service details: address, meta tags
 	addresses, err := net.InterfaceAddrs()
 	if err != nil {
 		logger.Error(err.Error())
@@ -174,6 +300,20 @@ func connectToConsul(envs map[string]string, logger *logging.Logger) *consul.Cli
 		}
 	}
     
+	err = client.Agent().ServiceRegister(serviceRegistration(envs, ipAddr, meta))
+	if err != nil {
+		logger.Error(err.Error())
+	}
+
+	return client, svc, err
+}
+
+// serviceRegistration ... registration of this instance, native Connect with a health check
+func serviceRegistration(envs map[string]string, ipAddr string, meta map[string]string) *consul.AgentServiceRegistration {
+
+	port, _ := strconv.Atoi(envs["SERVICE_PORT"])
+	tags := []string{"microservice", "http"}
+
 	var tlsSkip bool
 	var endpoint string
 	if envs["HTTPS"] == "true" {
@@ -186,8 +326,8 @@ func connectToConsul(envs map[string]string, logger *logging.Logger) *consul.Cli
 
 	// fill service registration, set native connect, set service check
 	service := &consul.AgentServiceRegistration{
-		ID:      "_" + serviceID,
-		Name:    serviceID,
+		ID:      serviceID,
+		Name:    serviceName,
 		Port:    port,
 		Address: ipAddr,
 		Tags:    tags,
@@ -203,10 +343,20 @@ func connectToConsul(envs map[string]string, logger *logging.Logger) *consul.Cli
 			TLSSkipVerify:                  tlsSkip,
 		},
 	}
+	// the agent has no Connect certificate to call /health with
+	if envs["CONNECT_TLS"] == "1" {
+		service.Check.HTTP = ""
+		service.Check.TLSSkipVerify = false
+		service.Check.TCP = net.JoinHostPort(ipAddr, envs["SERVICE_PORT"])
+	}
 
-	if err := client.Agent().ServiceRegister(service); err != nil {
+	return service
+}
+
+// deregisterService ... remove this instance from Consul, with the id it was registered with
+func deregisterService(client *consul.Client, logger *logging.Logger) {
+	if err := client.Agent().ServiceDeregister(serviceID); err != nil {
 		logger.Error(err.Error())
 	}
-
-	return client
+	logger.Debug("Consul service deregistration")
 }
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/efbar/minimal-service/logging"
+	consul "github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceDeregistration(t *testing.T) {
+	logger := &logging.Logger{
+		Logger: log.New(os.Stdout, "Test Logger: ", log.Ldate|log.Ltime),
+	}
+
+	// a Consul agent keeping the registered ids
+	registered := map[string]bool{}
+	var deregisterPath string
+	agent := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/agent/service/register":
+			service := consul.AgentServiceRegistration{}
+			json.NewDecoder(r.Body).Decode(&service)
+			registered[service.ID] = true
+		default:
+			deregisterPath = r.URL.Path
+			id := r.URL.Path[len("/v1/agent/service/deregister/"):]
+			if !registered[id] {
+				http.Error(rw, "Unknown service ID "+id, http.StatusNotFound)
+				return
+			}
+			delete(registered, id)
+		}
+	}))
+	defer agent.Close()
+
+	client, err := consul.NewClient(&consul.Config{Address: agent.Listener.Addr().String()})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	envs := map[string]string{"SERVICE_PORT": "9090"}
+	assert.NoError(t, client.Agent().ServiceRegister(serviceRegistration(envs, "10.0.0.1", nil)))
+	assert.Equal(t, map[string]bool{"_minimal-service": true}, registered)
+
+	deregisterService(client, logger)
+	assert.Equal(t, "/v1/agent/service/deregister/_minimal-service", deregisterPath)
+	assert.Empty(t, registered)
+}
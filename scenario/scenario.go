@@ -0,0 +1,165 @@
+package scenario
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/efbar/minimal-service/config"
+	"github.com/efbar/minimal-service/logging"
+	"gopkg.in/yaml.v3"
+)
+
+// Phase ... envs applied for a slice of the timeline
+type Phase struct {
+	Name     string            `json:"name" yaml:"name"`
+	Duration string            `json:"duration" yaml:"duration"`
+	Envs     map[string]string `json:"envs" yaml:"envs"`
+
+	duration time.Duration
+}
+
+// Scenario ... timeline of phases, played once or in loop
+type Scenario struct {
+	Name   string  `json:"name" yaml:"name"`
+	Loop   bool    `json:"loop" yaml:"loop"`
+	Phases []Phase `json:"phases" yaml:"phases"`
+}
+
+// Status ... where the player is in the timeline
+type Status struct {
+	Scenario  string            `json:"scenario"`
+	Running   bool              `json:"running"`
+	Phase     string            `json:"phase"`
+	Index     int               `json:"index"`
+	Iteration int               `json:"iteration"`
+	Elapsed   string            `json:"elapsed"`
+	Remaining string            `json:"remaining"`
+	Envs      map[string]string `json:"envs"`
+}
+
+// Load ... read a scenario from a JSON file, or a YAML one for any other extension
+func Load(path string) (*Scenario, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sc := &Scenario{}
+	if filepath.Ext(path) == ".json" {
+		err = json.Unmarshal(content, sc)
+	} else {
+		err = yaml.Unmarshal(content, sc)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sc.Phases) == 0 {
+		return nil, fmt.Errorf("scenario %s has no phases", path)
+	}
+	for i := range sc.Phases {
+		phase := &sc.Phases[i]
+		if len(phase.Name) == 0 {
+			phase.Name = fmt.Sprintf("phase-%d", i)
+		}
+		phase.duration, err = time.ParseDuration(phase.Duration)
+		if err != nil {
+			return nil, fmt.Errorf("phase %s: %s", phase.Name, err.Error())
+		}
+		if phase.duration <= 0 {
+			return nil, fmt.Errorf("phase %s: duration must be positive", phase.Name)
+		}
+	}
+
+	return sc, nil
+}
+
+// Player ... play the phases of a scenario as overlay envs of the store
+type Player struct {
+	scenario *Scenario
+	store    *config.Store
+	l        logging.Logger
+	// after ... the end of a phase, time.After out of tests
+	after func(time.Duration) <-chan time.Time
+
+	mu        sync.RWMutex
+	running   bool
+	index     int
+	iteration int
+	started   time.Time
+}
+
+// NewPlayer ...
+func NewPlayer(sc *Scenario, store *config.Store, l logging.Logger) *Player {
+	return &Player{
+		scenario: sc,
+		store:    store,
+		l:        l,
+		after:    time.After,
+	}
+}
+
+// Run ... play the timeline until its end, or forever if in loop, or until ctx is done
+func (p *Player) Run(ctx context.Context) {
+	defer p.stop()
+
+	for iteration := 0; iteration == 0 || p.scenario.Loop; iteration++ {
+		for index, phase := range p.scenario.Phases {
+			p.mu.Lock()
+			p.running = true
+			p.index = index
+			p.iteration = iteration
+			p.started = time.Now()
+			p.mu.Unlock()
+
+			p.store.SetOverlay(phase.Envs)
+			p.l.Info("Scenario", p.scenario.Name, "phase", phase.Name, "for", phase.duration.String())
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-p.after(phase.duration):
+			}
+		}
+	}
+}
+
+// stop ... clear the overlay envs, back to the base ones
+func (p *Player) stop() {
+	p.mu.Lock()
+	p.running = false
+	p.mu.Unlock()
+
+	p.store.SetOverlay(nil)
+	p.l.Info("Scenario", p.scenario.Name, "finished")
+}
+
+// Status ... current phase of the timeline
+func (p *Player) Status() Status {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	status := Status{
+		Scenario: p.scenario.Name,
+		Running:  p.running,
+	}
+	if !p.running {
+		return status
+	}
+
+	phase := p.scenario.Phases[p.index]
+	elapsed := time.Since(p.started)
+	status.Phase = phase.Name
+	status.Index = p.index
+	status.Iteration = p.iteration
+	status.Elapsed = elapsed.Round(time.Millisecond).String()
+	status.Remaining = (phase.duration - elapsed).Round(time.Millisecond).String()
+	status.Envs = phase.Envs
+
+	return status
+}
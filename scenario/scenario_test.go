@@ -0,0 +1,160 @@
+package scenario
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/efbar/minimal-service/config"
+	"github.com/efbar/minimal-service/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeScenario(t *testing.T, name string, content string) string {
+	file := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func setupPlayerTest(t *testing.T, content string, store *config.Store) *Player {
+	l := log.New(os.Stdout,
+		"Test Logger: ",
+		log.Ldate|log.Ltime)
+	logger := &logging.Logger{
+		Logger: l,
+	}
+
+	sc, err := Load(writeScenario(t, "drill.yaml", content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewPlayer(sc, store, *logger)
+}
+
+// manualClock ... end the phases of player only when tick is called
+func manualClock(player *Player) (wait func() time.Duration, tick func()) {
+	started := make(chan time.Duration)
+	ticks := make(chan time.Time)
+	player.after = func(d time.Duration) <-chan time.Time {
+		started <- d
+		return ticks
+	}
+
+	return func() time.Duration { return <-started }, func() { ticks <- time.Now() }
+}
+
+func TestLoad(t *testing.T) {
+	sc, err := Load(writeScenario(t, "drill.json",
+		`{"name":"drill","phases":[{"duration":"1m"},{"name":"broken","duration":"30s","envs":{"REJECT":"1"}}]}`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "drill", sc.Name)
+	assert.False(t, sc.Loop)
+	assert.Equal(t, "phase-0", sc.Phases[0].Name)
+	assert.Equal(t, time.Minute, sc.Phases[0].duration)
+	assert.Equal(t, "1", sc.Phases[1].Envs["REJECT"])
+
+	tt := []struct {
+		name    string
+		content string
+	}{
+		{"no phases", "name: empty"},
+		{"no duration", "phases:\n  - name: forever\n"},
+		{"not valid duration", "phases:\n  - duration: soon\n"},
+		{"negative duration", "phases:\n  - duration: -1s\n"},
+		{"not valid yaml", "phases: ["},
+	}
+	for _, tr := range tt {
+		t.Run(tr.name, func(t *testing.T) {
+			_, err := Load(writeScenario(t, "bad.yaml", tr.content))
+			assert.Error(t, err)
+		})
+	}
+
+	_, err = Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+}
+
+func TestPlayerRun(t *testing.T) {
+	store := config.NewStore(map[string]string{"REJECT": "0"})
+	player := setupPlayerTest(t, `
+name: drill
+phases:
+  - name: healthy
+    duration: 100ms
+  - name: degraded
+    duration: 100ms
+    envs:
+      REJECT: "1"
+`, store)
+
+	wait, tick := manualClock(player)
+	done := make(chan struct{})
+	go func() {
+		player.Run(context.Background())
+		close(done)
+	}()
+
+	assert.Equal(t, 100*time.Millisecond, wait())
+	status := player.Status()
+	assert.True(t, status.Running)
+	assert.Equal(t, "healthy", status.Phase)
+	assert.Equal(t, "0", store.Get("REJECT"))
+
+	tick()
+	wait()
+	status = player.Status()
+	assert.Equal(t, "degraded", status.Phase)
+	assert.Equal(t, 1, status.Index)
+	assert.Equal(t, "1", store.Get("REJECT"))
+
+	// played once, then back to the base envs
+	tick()
+	<-done
+	assert.False(t, player.Status().Running)
+	assert.Equal(t, "0", store.Get("REJECT"))
+}
+
+func TestPlayerLoop(t *testing.T) {
+	store := config.NewStore(nil)
+	player := setupPlayerTest(t, `
+name: drill
+loop: true
+phases:
+  - duration: 50ms
+    envs:
+      DELAY_MS: "10"
+`, store)
+
+	wait, tick := manualClock(player)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		player.Run(ctx)
+		close(done)
+	}()
+
+	for i := 0; i < 2; i++ {
+		wait()
+		tick()
+	}
+	wait()
+	status := player.Status()
+	assert.True(t, status.Running)
+	assert.Equal(t, 2, status.Iteration)
+	assert.Equal(t, "10", store.Get("DELAY_MS"))
+
+	cancel()
+	<-done
+	assert.False(t, player.Status().Running)
+	assert.Equal(t, "", store.Get("DELAY_MS"))
+}
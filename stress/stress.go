@@ -0,0 +1,231 @@
+package stress
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/efbar/minimal-service/logging"
+)
+
+// mib ... bytes in a MiB, the unit of memory and disk stressors
+const mib = 1 << 20
+
+// Kinds ... resources that can be stressed, with the unit of their amount
+var Kinds = map[string]string{
+	"cpu":        "cores",
+	"memory":     "MiB",
+	"goroutines": "goroutines",
+	"disk":       "MiB",
+}
+
+// Stressor ... a running consumer of resources
+type Stressor struct {
+	ID        string `json:"id"`
+	Kind      string `json:"kind"`
+	Amount    int    `json:"amount"`
+	Unit      string `json:"unit"`
+	Duration  string `json:"duration"`
+	Remaining string `json:"remaining"`
+
+	until  time.Time
+	cancel context.CancelFunc
+}
+
+// Registry ... the running stressors
+type Registry struct {
+	mu      sync.Mutex
+	log     logging.Logger
+	next    int
+	running map[string]*Stressor
+}
+
+// NewRegistry ...
+func NewRegistry(l logging.Logger) *Registry {
+	return &Registry{
+		log:     l,
+		running: map[string]*Stressor{},
+	}
+}
+
+// Start ... consume amount of the kind resource for d, as long as the amount
+// of the stressors of the same kind stays under limit
+func (reg *Registry) Start(kind string, amount int, d time.Duration, limit int) (Stressor, error) {
+	unit, ok := Kinds[kind]
+	if !ok {
+		return Stressor{}, fmt.Errorf("unknown stressor %s", kind)
+	}
+	if amount <= 0 {
+		return Stressor{}, fmt.Errorf("amount of %s must be positive", unit)
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	used := 0
+	for _, s := range reg.running {
+		if s.Kind == kind {
+			used += s.Amount
+		}
+	}
+	if used+amount > limit {
+		return Stressor{}, fmt.Errorf("%d %s over the limit of %d %s, %d in use", amount, unit, limit, unit, used)
+	}
+
+	reg.next++
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	s := &Stressor{
+		ID:       kind + "-" + strconv.Itoa(reg.next),
+		Kind:     kind,
+		Amount:   amount,
+		Unit:     unit,
+		Duration: d.String(),
+		until:    time.Now().Add(d),
+		cancel:   cancel,
+	}
+	reg.running[s.ID] = s
+
+	go func() {
+		reg.log.Info("Stressor", s.ID, "started,", strconv.Itoa(amount), unit, "for", d.String())
+		if err := stress(ctx, kind, amount); err != nil {
+			reg.log.Error("Stressor", s.ID+",", err.Error())
+		}
+		cancel()
+		reg.mu.Lock()
+		delete(reg.running, s.ID)
+		reg.mu.Unlock()
+		reg.log.Info("Stressor", s.ID, "stopped")
+	}()
+
+	return reg.snapshot(s), nil
+}
+
+// List ... the running stressors, sorted by id
+func (reg *Registry) List() []Stressor {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	list := []Stressor{}
+	for _, s := range reg.running {
+		list = append(list, reg.snapshot(s))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+
+	return list
+}
+
+// Cancel ... stop the stressor with id, false if it is not running
+func (reg *Registry) Cancel(id string) bool {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	s, ok := reg.running[id]
+	if ok {
+		s.cancel()
+	}
+
+	return ok
+}
+
+// CancelAll ... stop every stressor, returning how many were running
+func (reg *Registry) CancelAll() int {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	for _, s := range reg.running {
+		s.cancel()
+	}
+
+	return len(reg.running)
+}
+
+// snapshot ... copy of s with the remaining time, reg.mu must be held
+func (reg *Registry) snapshot(s *Stressor) Stressor {
+	snap := *s
+	snap.Remaining = time.Until(s.until).Round(time.Second).String()
+
+	return snap
+}
+
+// stress ... consume the resource until ctx is done
+func stress(ctx context.Context, kind string, amount int) error {
+	switch kind {
+	case "cpu":
+		for i := 0; i < amount; i++ {
+			go burn(ctx)
+		}
+	case "memory":
+		hold(ctx, amount)
+		// the memory held is unreachable now, give it back to the OS
+		debug.FreeOSMemory()
+		return nil
+	case "goroutines":
+		for i := 0; i < amount; i++ {
+			go func() {
+				<-ctx.Done()
+			}()
+		}
+	case "disk":
+		return fill(ctx, amount)
+	}
+
+	<-ctx.Done()
+	return nil
+}
+
+// burn ... keep a core busy until ctx is done
+func burn(ctx context.Context) {
+	for n := 0; ; n++ {
+		if n%1000 == 0 && ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// hold ... keep amount MiB resident until ctx is done
+func hold(ctx context.Context, amount int) {
+	chunks := make([][]byte, amount)
+	for i := range chunks {
+		chunks[i] = make([]byte, mib)
+		// touch every page, or they won't be resident
+		for j := 0; j < mib; j += os.Getpagesize() {
+			chunks[i][j] = 1
+		}
+	}
+
+	<-ctx.Done()
+	runtime.KeepAlive(chunks)
+}
+
+// fill ... write amount MiB to a temporary file, removed when ctx is done
+func fill(ctx context.Context, amount int) error {
+	f, err := ioutil.TempFile("", "minimal-service-stress-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	chunk := make([]byte, mib)
+	for i := 0; i < amount; i++ {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if _, err := f.Write(chunk); err != nil {
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	<-ctx.Done()
+	return nil
+}
@@ -0,0 +1,149 @@
+package stress
+
+import (
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/efbar/minimal-service/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupRegistryTest() *Registry {
+	l := log.New(os.Stdout,
+		"Test Logger: ",
+		log.Ldate|log.Ltime)
+	logger := &logging.Logger{
+		Logger: l,
+	}
+
+	return NewRegistry(*logger)
+}
+
+func TestRegistryStart(t *testing.T) {
+	tt := []struct {
+		name   string
+		kind   string
+		amount int
+		limit  int
+		unit   string
+		err    bool
+	}{
+		{
+			name:   "goroutines",
+			kind:   "goroutines",
+			amount: 10,
+			limit:  100,
+			unit:   "goroutines",
+		},
+		{
+			name:   "memory",
+			kind:   "memory",
+			amount: 1,
+			limit:  4,
+			unit:   "MiB",
+		},
+		{
+			name:   "disk",
+			kind:   "disk",
+			amount: 1,
+			limit:  2,
+			unit:   "MiB",
+		},
+		{
+			name:   "over the limit",
+			kind:   "goroutines",
+			amount: 101,
+			limit:  100,
+			err:    true,
+		},
+		{
+			name:   "zero amount",
+			kind:   "memory",
+			amount: 0,
+			limit:  4,
+			err:    true,
+		},
+		{
+			name:   "unknown stressor",
+			kind:   "network",
+			amount: 1,
+			limit:  1,
+			err:    true,
+		},
+	}
+
+	for _, tr := range tt {
+		reg := setupRegistryTest()
+
+		t.Run(tr.name, func(t *testing.T) {
+			s, err := reg.Start(tr.kind, tr.amount, 10*time.Second, tr.limit)
+			if tr.err {
+				assert.Error(t, err)
+				assert.Empty(t, reg.List())
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, tr.kind+"-1", s.ID)
+				assert.Equal(t, tr.amount, s.Amount)
+				assert.Equal(t, tr.unit, s.Unit)
+				assert.Equal(t, "10s", s.Duration)
+			}
+
+			assert.Equal(t, 1, reg.CancelAll())
+			assert.Eventually(t, func() bool { return len(reg.List()) == 0 }, 5*time.Second, 10*time.Millisecond)
+		})
+	}
+}
+
+func TestRegistryLimit(t *testing.T) {
+	reg := setupRegistryTest()
+	defer reg.CancelAll()
+
+	_, err := reg.Start("goroutines", 60, 10*time.Second, 100)
+	assert.NoError(t, err)
+	_, err = reg.Start("goroutines", 40, 10*time.Second, 100)
+	assert.NoError(t, err)
+
+	// the limit is for all the stressors of the same kind
+	_, err = reg.Start("goroutines", 1, 10*time.Second, 100)
+	assert.Error(t, err)
+	_, err = reg.Start("memory", 1, 10*time.Second, 4)
+	assert.NoError(t, err)
+
+	list := reg.List()
+	if assert.Len(t, list, 3) {
+		assert.Equal(t, "goroutines-1", list[0].ID)
+		assert.Equal(t, "goroutines-2", list[1].ID)
+		assert.Equal(t, "memory-3", list[2].ID)
+	}
+}
+
+func TestRegistryCancel(t *testing.T) {
+	reg := setupRegistryTest()
+	defer reg.CancelAll()
+
+	s, err := reg.Start("goroutines", 10, 10*time.Second, 100)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.True(t, reg.Cancel(s.ID))
+	assert.Eventually(t, func() bool { return len(reg.List()) == 0 }, time.Second, 10*time.Millisecond)
+	assert.False(t, reg.Cancel(s.ID))
+	assert.Equal(t, 0, reg.CancelAll())
+
+	// the amount of a cancelled stressor is available again
+	_, err = reg.Start("goroutines", 100, 10*time.Second, 100)
+	assert.NoError(t, err)
+}
+
+func TestRegistryExpire(t *testing.T) {
+	reg := setupRegistryTest()
+
+	_, err := reg.Start("goroutines", 10, 50*time.Millisecond, 100)
+	assert.NoError(t, err)
+	assert.Len(t, reg.List(), 1)
+	assert.Eventually(t, func() bool { return len(reg.List()) == 0 }, time.Second, 10*time.Millisecond)
+}
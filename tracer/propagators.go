@@ -0,0 +1,178 @@
+package tracer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// propagators ... trace context formats, by the name used in TRACE_PROPAGATORS
+var propagators = map[string]propagation.TextMapPropagator{
+	"tracecontext": propagation.TraceContext{},
+	"b3":           B3{SingleHeader: true},
+	"b3multi":      B3{},
+	"jaeger":       Jaeger{},
+}
+
+// Propagator ... propagator of all the comma separated formats in names
+func Propagator(names string) (propagation.TextMapPropagator, error) {
+	var list []propagation.TextMapPropagator
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		p, ok := propagators[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown trace propagator %s", name)
+		}
+		list = append(list, p)
+	}
+
+	return propagation.NewCompositeTextMapPropagator(list...), nil
+}
+
+// HeaderCarrier ... http headers as carrier of the trace context
+type HeaderCarrier http.Header
+
+// Get ...
+func (hc HeaderCarrier) Get(key string) string {
+	return http.Header(hc).Get(key)
+}
+
+// Set ...
+func (hc HeaderCarrier) Set(key string, value string) {
+	http.Header(hc).Set(key, value)
+}
+
+// B3 ... Zipkin B3 propagator, the single b3 header or the multiple X-B3-* ones,
+// both are extracted
+type B3 struct {
+	SingleHeader bool
+}
+
+// Inject ...
+func (b B3) Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+
+	sampled := "0"
+	if sc.IsSampled() {
+		sampled = "1"
+	}
+	if b.SingleHeader {
+		carrier.Set("b3", sc.TraceID.String()+"-"+sc.SpanID.String()+"-"+sampled)
+		return
+	}
+	carrier.Set("X-B3-TraceId", sc.TraceID.String())
+	carrier.Set("X-B3-SpanId", sc.SpanID.String())
+	carrier.Set("X-B3-Sampled", sampled)
+}
+
+// Extract ...
+func (b B3) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	var sc trace.SpanContext
+	var err error
+	if single := carrier.Get("b3"); len(single) != 0 {
+		sc, err = parseB3Single(single)
+	} else {
+		sc, err = parseIDs(carrier.Get("X-B3-TraceId"), carrier.Get("X-B3-SpanId"))
+		switch carrier.Get("X-B3-Sampled") {
+		case "1", "true":
+			sc.TraceFlags = trace.FlagsSampled
+		}
+		if carrier.Get("X-B3-Flags") == "1" {
+			sc.TraceFlags = trace.FlagsSampled
+		}
+	}
+	if err != nil || !sc.IsValid() {
+		return ctx
+	}
+
+	return trace.ContextWithRemoteSpanContext(ctx, sc)
+}
+
+// Fields ...
+func (b B3) Fields() []string {
+	if b.SingleHeader {
+		return []string{"b3"}
+	}
+	return []string{"X-B3-TraceId", "X-B3-SpanId", "X-B3-Sampled"}
+}
+
+// parseB3Single ... parse a b3 header like {TraceId}-{SpanId}-{SamplingState}-{ParentSpanId},
+// the last two are optional
+func parseB3Single(value string) (trace.SpanContext, error) {
+	parts := strings.Split(value, "-")
+	if len(parts) < 2 {
+		return trace.SpanContext{}, fmt.Errorf("b3 header without ids")
+	}
+	sc, err := parseIDs(parts[0], parts[1])
+	if err != nil {
+		return sc, err
+	}
+	if len(parts) > 2 && (parts[2] == "1" || parts[2] == "d") {
+		sc.TraceFlags = trace.FlagsSampled
+	}
+
+	return sc, nil
+}
+
+// Jaeger ... propagator of the uber-trace-id header, {trace-id}:{span-id}:{parent-span-id}:{flags}
+type Jaeger struct{}
+
+// Inject ...
+func (j Jaeger) Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+
+	carrier.Set("uber-trace-id", fmt.Sprintf("%s:%s:0:%x", sc.TraceID, sc.SpanID, sc.TraceFlags&trace.FlagsSampled))
+}
+
+// Extract ...
+func (j Jaeger) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	parts := strings.Split(carrier.Get("uber-trace-id"), ":")
+	if len(parts) != 4 {
+		return ctx
+	}
+	sc, err := parseIDs(parts[0], parts[1])
+	if err != nil || !sc.IsValid() {
+		return ctx
+	}
+	var flags byte
+	if _, err := fmt.Sscanf(parts[3], "%x", &flags); err == nil && flags&(trace.FlagsSampled|trace.FlagsDebug) != 0 {
+		sc.TraceFlags = trace.FlagsSampled
+	}
+
+	return trace.ContextWithRemoteSpanContext(ctx, sc)
+}
+
+// Fields ...
+func (j Jaeger) Fields() []string {
+	return []string{"uber-trace-id"}
+}
+
+// parseIDs ... parse hex trace and span ids, shorter ones are left padded with zeros
+func parseIDs(traceID string, spanID string) (trace.SpanContext, error) {
+	var sc trace.SpanContext
+	if len(traceID) == 0 || len(traceID) > 32 || len(spanID) == 0 || len(spanID) > 16 {
+		return sc, fmt.Errorf("not valid trace or span id")
+	}
+
+	var err error
+	sc.TraceID, err = trace.TraceIDFromHex(strings.Repeat("0", 32-len(traceID)) + strings.ToLower(traceID))
+	if err != nil {
+		return sc, err
+	}
+	sc.SpanID, err = trace.SpanIDFromHex(strings.Repeat("0", 16-len(spanID)) + strings.ToLower(spanID))
+
+	return sc, err
+}
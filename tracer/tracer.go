@@ -1,76 +1,99 @@
 package tracer
 
 import (
-	"context"
 	"net"
 	"net/url"
+	"sync"
 	"time"
 
-	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/trace"
 
 	"go.opentelemetry.io/otel/exporters/trace/jaeger"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
-
-	"github.com/efbar/minimal-service/logging"
 )
 
-// TraceObject ...
-type TraceObject struct {
+// retryInit ... time before trying again to create the trace provider after a failure
+const retryInit = 10 * time.Second
+
+// provider ... the trace provider of the service, created at the first traced request
+var provider struct {
+	mu       sync.Mutex
+	flush    func()
+	err      error
+	failedAt time.Time
 }
 
-// Opentracer ... create tracing provider
-func (tObj *TraceObject) Opentracer(jaegerURL string, service string, code int, message string, tags []label.KeyValue, l *logging.Logger, envs map[string]string) error {
+// Init ... create the trace provider exporting to Jaeger and register it as global provider,
+// only the first successful call creates it. After a failure, like Jaeger not accepting
+// connections, the same error is returned for retryInit before trying again
+func Init(jaegerURL string, service string, tags []label.KeyValue) error {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	if provider.flush != nil {
+		return nil
+	}
+	if provider.err != nil && time.Since(provider.failedAt) < retryInit {
+		return provider.err
+	}
 
 	if len(jaegerURL) == 0 {
 		jaegerURL = "http://localhost:14268/api/traces"
 	}
+	provider.err = Reachable(jaegerURL)
+	if provider.err == nil {
+		// Create and install Jaeger export pipeline.
+		provider.flush, provider.err = jaeger.InstallNewPipeline(
+			jaeger.WithCollectorEndpoint(jaegerURL),
+			jaeger.WithProcess(jaeger.Process{
+				ServiceName: service,
+				Tags:        tags,
+			}),
+			jaeger.WithSDK(&sdktrace.Config{DefaultSampler: sdktrace.AlwaysSample()}),
+		)
+	}
+	if provider.err != nil {
+		provider.flush = nil
+		provider.failedAt = time.Now()
+	}
+
+	return provider.err
+}
+
+// Flush ... send the spans still buffered
+func Flush() {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	if provider.flush != nil {
+		provider.flush()
+	}
+}
+
+// Reachable ... check that the Jaeger collector accepts tcp connections
+func Reachable(jaegerURL string) error {
 	url, err := url.ParseRequestURI(jaegerURL)
 	if err != nil {
 		return err
 	}
 
-	// check if you can contact jaeger
-	_, err = net.DialTimeout("tcp", net.JoinHostPort(url.Hostname(), url.Port()), time.Second)
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(url.Hostname(), url.Port()), time.Second)
 	if err != nil {
 		return err
 	}
 
-	l.Debug(envs["DEBUG"], "JAEGER_URL", jaegerURL)
-
-	ctx := context.Background()
-
-	// create trace provider
-	flush, err := tObj.initTracer(jaegerURL, service, tags, l, envs)
-	defer flush()
+	return conn.Close()
+}
 
-	// start tracing and set errors
-	tr := otel.Tracer(service)
-	ctx, span := tr.Start(ctx, "request-span")
+// Annotate ... set the status code, the message and the tags of a request on its span
+func Annotate(span trace.Span, code int, message string, tags []label.KeyValue) {
+	span.SetAttributes(tags...)
+	span.SetAttributes(label.Int("http.status_code", code))
 	if code == 200 {
-		span.SetStatus(2, message)
+		span.SetStatus(codes.Ok, message)
 	} else {
-		span.SetStatus(1, message)
-	}
-	defer span.End()
-
-	return err
-}
-
-// initTracer creates a new trace provider instance and registers it as global trace provider.
-func (tObj *TraceObject) initTracer(jaegerURL string, service string, tags []label.KeyValue, l *logging.Logger, envs map[string]string) (func(), error) {
-
-	// Create and install Jaeger export pipeline.
-	flush, err := jaeger.InstallNewPipeline(
-		jaeger.WithCollectorEndpoint(jaegerURL),
-		jaeger.WithProcess(jaeger.Process{
-			ServiceName: service,
-			Tags:        tags,
-		}),
-		jaeger.WithSDK(&sdktrace.Config{DefaultSampler: sdktrace.AlwaysSample()}),
-	)
-	if err != nil {
-		l.Error(err.Error())
+		span.SetStatus(codes.Error, message)
 	}
-	return flush, err
 }
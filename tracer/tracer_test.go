@@ -0,0 +1,30 @@
+package tracer
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInitRetry(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	jaegerURL := "http://" + addr + "/api/traces"
+
+	first := Init(jaegerURL, "minimal-service", nil)
+	assert.Error(t, first)
+
+	// Jaeger is back, but it is not dialed again before retryInit
+	ln, err = net.Listen("tcp", addr)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer ln.Close()
+	assert.Equal(t, first, Init(jaegerURL, "minimal-service", nil))
+	assert.NoError(t, Reachable(jaegerURL))
+}